@@ -1,8 +1,10 @@
 package bot
 
 import (
+	"context"
 	"github.com/alexcom/tba/telegram"
 	"github.com/sirupsen/logrus"
+	"path/filepath"
 	"time"
 )
 
@@ -14,25 +16,131 @@ type Options struct {
 	FailRetryInterval  int
 	Authorized         AuthZFunction
 	WorkingDir         string
+	// Store keeps bot state, by default state is kept in store.log under WorkingDir, opened on first use
+	Store KVStore
+	// StatusStore keeps last handled update ID, by default it is kept in Store if set or in status.json otherwise
+	StatusStore StatusStore
+	// Workers is number of updates handled concurrently, updates of one chat or user are always handled in order.
+	// Handler which never returns holds later updates of its chat and a worker, once every worker is held
+	// or queue is full polling stops. Use Timeout middleware or HandlerTimeout to bound handlers.
+	Workers int
+	// QueueSize is number of updates per worker waiting to be handled before polling is paused
+	QueueSize int
+	// Delivery tells when polled updates are committed and so are not received again, DeliveryBatch by default
+	Delivery DeliveryMode
+	// Deduplicator skips updates which were handled already, nothing is skipped by default
+	Deduplicator Deduplicator
+	// DeadLetters keeps updates whose handlers failed, by default they are kept in Store if set
+	// or in dead_letters.log under WorkingDir otherwise
+	DeadLetters DeadLetterStore
+	// ConversationStore keeps state of conversations in progress, by default it is kept in Store
+	ConversationStore ConversationStore
+	// SessionStore keeps sessions available to handlers with SessionFrom, by default in sessions under WorkingDir
+	SessionStore SessionStore
+	// SessionScope tells whether session belongs to user in chat, whole chat or user, ScopeChatUser by default.
+	// With ScopeUser updates of one user from different chats are handled one at a time.
+	SessionScope ConversationScope
+	// SessionTTL expires sessions unused for longer, zero means sessions never expire
+	SessionTTL time.Duration
+	// AllowedUpdates overrides update types requested from Telegram, by default they are derived from handlers
+	AllowedUpdates []telegram.UpdateType
+	// SyncCommands publishes commands registered with OnCommand as commands menu when bot starts
+	SyncCommands bool
+	// Roles tells roles of update sender, they are checked against RequireRole of commands. Nobody has roles by default.
+	Roles RoleFunc
+	// APIEndpoint overrides Bot API server address, defaults to telegram.DefaultAPIEndpoint
+	APIEndpoint string
+	// LocalAPIServer must be set when APIEndpoint is telegram-bot-api running with --local
+	LocalAPIServer bool
+	// RetryMigratedChats repeats requests failed because group was upgraded to supergroup against new chat ID
+	RetryMigratedChats bool
+	// ClientOptions are applied to Telegram client after options above, e.g. telegram.WithRetryPolicy
+	ClientOptions []telegram.ClientOption
 }
 
 // Constructor for Bot
 func NewBot(opts Options) (*Bot, error) {
-	status, err := LoadStatus(opts.WorkingDir)
+	statusStore := opts.StatusStore
+	if statusStore == nil && opts.Store != nil {
+		statusStore = NewKVStatusStore(opts.Store)
+	}
+	if statusStore == nil {
+		statusStore = NewFileStatusStore(filepath.Join(opts.WorkingDir, statusFileName))
+	}
+	status, err := LoadStatusFrom(statusStore)
 	if err != nil {
 		return nil, err
 	}
+	// default stores are opened on first use and closed by Close, injected ones are left to the caller
+	var ownStores []*lazyFileKVStore
+	store := opts.Store
+	if store == nil {
+		defaultStore := newLazyFileKVStore(filepath.Join(opts.WorkingDir, storeFileName))
+		ownStores = append(ownStores, defaultStore)
+		store = defaultStore
+	}
+	deadLetters := opts.DeadLetters
+	if deadLetters == nil && opts.Store != nil {
+		deadLetters = NewKVDeadLetterStore(opts.Store)
+	}
+	if deadLetters == nil {
+		kv := newLazyFileKVStore(filepath.Join(opts.WorkingDir, deadLettersFileName))
+		ownStores = append(ownStores, kv)
+		deadLetters = NewKVDeadLetterStore(kv)
+	}
+	conversationStore := opts.ConversationStore
+	if conversationStore == nil {
+		conversationStore = NewKVConversationStore(store)
+	}
+	sessionStore := opts.SessionStore
+	if sessionStore == nil {
+		sessionStore = NewFileSessionStore(filepath.Join(opts.WorkingDir, sessionsDirName))
+	}
 	authZFunc := opts.Authorized
 	if authZFunc == nil {
 		authZFunc = func(*telegram.Update) (bool, int, string) {
 			return true, 0, ""
 		}
 	}
+	var clientOpts []telegram.ClientOption
+	if opts.APIEndpoint != "" {
+		clientOpts = append(clientOpts, telegram.WithAPIEndpoint(opts.APIEndpoint))
+	}
+	if opts.LocalAPIServer {
+		clientOpts = append(clientOpts, telegram.WithLocalMode())
+	}
+	if opts.RetryMigratedChats {
+		clientOpts = append(clientOpts, telegram.WithMigrationRetry())
+	}
+	chatMigrations := newMigrations()
+	clientOpts = append(clientOpts, telegram.WithMigrationHandler(func(fromChatID, toChatID int) {
+		chatMigrations.emit(ChatMigration{FromChatID: fromChatID, ToChatID: toChatID})
+	}))
+	clientOpts = append(clientOpts, opts.ClientOptions...)
 	bot := Bot{
 		failRetryInterval: opts.FailRetryInterval,
-		Telegram:          telegram.NewClient(opts.APIToken, opts.LongPollingTimeout),
-		status:            *status,
+		Telegram:          telegram.NewClient(opts.APIToken, opts.LongPollingTimeout, clientOpts...),
+		status:            status,
+		workers:           opts.Workers,
+		queueSize:         opts.QueueSize,
+		delivery:          opts.Delivery,
+		deduplicator:      opts.Deduplicator,
+		deadLetters:       deadLetters,
+		callbacks:         newCallbackCodec(store),
+		journal:           newUpdateJournal(store),
+		conversationStore: conversationStore,
+		sessionStore:      sessionStore,
+		sessionScope:      opts.SessionScope,
+		sessionTTL:        opts.SessionTTL,
+		allowedUpdates:    opts.AllowedUpdates,
+		syncCommands:      opts.SyncCommands,
+		roles:             opts.Roles,
+		store:             store,
+		ownStores:         ownStores,
 		authorized:        authZFunc,
+		migrations:        chatMigrations,
+		identity:          &identity{},
+		userLocks:         newKeyLocks(),
 	}
 	return &bot, nil
 }
@@ -41,55 +149,210 @@ type Bot struct {
 	authorized        AuthZFunction
 	failRetryInterval int
 	Telegram          *telegram.BaseClient
-	status            Status
-	updateHandlers    []UpdateHandler
+	status            *Status
+	workers           int
+	queueSize         int
+	delivery          DeliveryMode
+	deduplicator      Deduplicator
+	deadLetters       DeadLetterStore
+	callbacks         *callbackCodec
+	journal           *updateJournal
+	updateHandlers    []registeredHandler
+	// priorityHandlers is number of conversation handlers at the start of updateHandlers
+	priorityHandlers  int
+	conversations     []*Conversation
+	conversationStore ConversationStore
+	sessionStore      SessionStore
+	sessionScope      ConversationScope
+	sessionTTL        time.Duration
+	allowedUpdates    []telegram.UpdateType
+	syncCommands      bool
+	roles             RoleFunc
+	commands          []*commandInfo
+	store             KVStore
+	ownStores         []*lazyFileKVStore
+	middlewares       []Middleware
+	migrations        *migrations
+	identity          *identity
+	userLocks         *keyLocks
+}
+
+// Close closes store files bot opened by default, stores given in Options are not closed.
+// Bot must not handle updates after Close.
+func (bot *Bot) Close() error {
+	var firstErr error
+	for _, store := range bot.ownStores {
+		if err := store.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Run polls updates until failure, see RunContext
+func (bot Bot) Run() error {
+	return bot.RunContext(context.Background())
 }
 
-func (bot Bot) Run() {
+// RunContext polls updates and dispatches them to registered handlers until ctx is done.
+// Cancelling ctx aborts long polling request in flight, waits for handlers in progress, saves status
+// and returns ctx.Err(). When updates are committed is controlled by Options.Delivery.
+func (bot Bot) RunContext(ctx context.Context) error {
+	bot.syncCommandsOnStart(ctx)
+	pool := bot.startWorkers(ctx)
+	defer func() {
+		pool.stop()
+		bot.saveStatus(pool.tracker)
+	}()
+	if err := bot.replayJournal(ctx, pool); err != nil {
+		return err
+	}
+	allowedUpdates := bot.AllowedUpdates()
 	for {
-		updates, err := bot.Telegram.GetUpdates(telegram.GetUpdatesRequest{
-			Offset: bot.status.LastUpdate() + 1,
+		// Telegram confirms every update below offset and never sends it again, updates in progress
+		// are kept in journal by then
+		updates, err := bot.Telegram.GetUpdatesContext(ctx, telegram.GetUpdatesRequest{
+			Offset:         pool.tracker.fetched() + 1,
+			AllowedUpdates: allowedUpdates,
 		})
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		if err != nil {
 			logrus.WithError(err).Error("update receive failure")
-			time.Sleep(time.Duration(bot.failRetryInterval) * time.Second)
+			if err := bot.waitRetry(ctx); err != nil {
+				return err
+			}
 			continue
 		}
-		for _, update := range *updates {
-			if allowed, chatID, message := bot.authorized(&update); allowed {
-				bot.processUpdate(&update)
-			} else {
-				_, err = bot.SendMarkdown(chatID, "*"+message+"*")
-				if err != nil {
-					logrus.WithError(err).Error("sending service message failed")
-				}
+		fresh := pool.tracker.fresh(*updates)
+		var dispatched []*telegram.Update
+		for _, update := range fresh {
+			if err := pool.tracker.dispatched(update); err != nil {
+				// update is neither confirmed nor handled, it is received again on the next poll
+				logrus.WithError(err).Errorf("journaling update %d", update.UpdateID)
+				break
+			}
+			dispatched = append(dispatched, update)
+		}
+		if bot.delivery == DeliveryAtMostOnce {
+			bot.saveStatus(pool.tracker)
+		}
+		for _, update := range dispatched {
+			if err := pool.submit(ctx, update); err != nil {
+				return err
 			}
-			bot.status.SetUpdate(update.UpdateID)
 		}
-		if bot.status.Changed() {
-			if err = bot.status.Save(); err != nil {
-				logrus.WithError(err).Error("fail saving status file : ", bot.status.filename)
+		bot.saveStatus(pool.tracker)
+		if len(dispatched) < len(fresh) {
+			if err := bot.waitRetry(ctx); err != nil {
+				return err
 			}
 		}
 	}
 }
 
-func (bot *Bot) processUpdate(update *telegram.Update) {
+// replayJournal dispatches updates left unhandled by previous run, journaled updates committed already are dropped
+func (bot Bot) replayJournal(ctx context.Context, pool *workerPool) error {
+	if pool.tracker.journal == nil {
+		return nil
+	}
+	updates, err := pool.tracker.journal.list()
+	if err != nil {
+		return err
+	}
+	committed := pool.tracker.committed()
+	for _, update := range updates {
+		if update.UpdateID <= committed {
+			if err := pool.tracker.journal.delete(update.UpdateID); err != nil {
+				return err
+			}
+			continue
+		}
+		logrus.Infof("replaying update %d left unhandled", update.UpdateID)
+		pool.tracker.replayed(update)
+		if err := pool.submit(ctx, update); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (bot Bot) waitRetry(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(time.Duration(bot.failRetryInterval) * time.Second):
+		return nil
+	}
+}
+
+func (bot Bot) saveStatus(tracker *offsetTracker) {
+	if err := tracker.save(); err != nil {
+		logrus.WithError(err).Error("fail saving status")
+	}
+}
+
+// handleUpdate checks authorization and dispatches update received either by polling or by webhook
+func (bot *Bot) handleUpdate(ctx context.Context, update *telegram.Update) {
+	if bot.deduplicator != nil {
+		claimed, err := bot.deduplicator.Claim(update.UpdateID)
+		if err != nil {
+			// handling twice is better than losing update
+			logrus.WithError(err).Error("checking update for duplicate")
+		} else if !claimed {
+			logrus.Infof("skipping update %d handled already", update.UpdateID)
+			return
+		}
+	}
+	bot.migrations.emitFromUpdate(update)
+	if allowed, chatID, message := bot.authorized(update); allowed {
+		if failure := bot.processUpdate(ctx, update); failure != nil {
+			bot.deadLetter(update, failure)
+		}
+	} else {
+		_, err := bot.SendMarkdown(chatID, "*"+message+"*")
+		if err != nil {
+			logrus.WithError(err).Error("sending service message failed")
+		}
+	}
+	if bot.deduplicator != nil {
+		if err := bot.deduplicator.MarkHandled(update.UpdateID); err != nil {
+			logrus.WithError(err).Error("marking update handled")
+		}
+	}
+}
+
+// processUpdate passes update through the handler chain and reports the first handler which failed
+func (bot *Bot) processUpdate(ctx context.Context, update *telegram.Update) *handlerFailure {
 
 	if len(bot.updateHandlers) == 0 {
 		logrus.Warn("no handlers registered. Please use OnX(...) methods to register some")
-		return
+		return nil
 	}
 
-	for _, handler := range bot.updateHandlers {
-		stop, err := handler(bot, update)
+	if bot.userScoped() {
+		// workers are picked by chat, so updates of one user from different chats are serialized here
+		if key, ok := conversationKey(ScopeUser, update); ok {
+			defer bot.userLocks.lock(key)()
+		}
+	}
+	scope := newUpdateScope(ctx, bot, update)
+	defer scope.close()
+	var failure *handlerFailure
+	for _, registered := range bot.updateHandlers {
+		stop, err := bot.wrap(registered.handler)(scope, update)
 		if err != nil {
-			logrus.WithError(err).Error("handling update")
+			logrus.WithError(err).WithField("handler", registered.name).Error("handling update")
+			if failure == nil {
+				failure = &handlerFailure{handler: registered.name, err: err}
+			}
 		}
 		if stop {
-			return
+			return failure
 		}
 	}
+	return failure
 }
 
 type UpdateHandler func(services MessageServices, update *telegram.Update) (breakChain bool, err error)
@@ -105,95 +368,135 @@ type PreCheckoutQueryHandler func(services MessageServices, update *telegram.Pre
 type PollHandler func(services MessageServices, update *telegram.Poll) (breakChain bool, err error)
 
 func (bot *Bot) OnUpdate(handler UpdateHandler) {
-	bot.updateHandlers = append(bot.updateHandlers, handler)
+	bot.register(handlerName(handler), bot.contextHandler(func(c *Context) (breakChain bool, err error) {
+		return handler(c, c.Update)
+	}))
+}
+
+// register adds handler which may be interested in any update type
+func (bot *Bot) register(name string, handler UpdateHandler) {
+	bot.registerFor(name, nil, handler)
+}
+
+// registerFor adds handler interested in updateTypes only, they are used to compute allowed updates
+func (bot *Bot) registerFor(name string, updateTypes []telegram.UpdateType, handler UpdateHandler) {
+	bot.updateHandlers = append(bot.updateHandlers, registeredHandler{name: name, handler: handler, updateTypes: updateTypes})
+}
+
+// AllowedUpdates returns update types requested from Telegram: Options.AllowedUpdates if set,
+// otherwise types handlers were registered for. Handlers registered with OnUpdate, HandleFunc or Handle
+// may be interested in anything, so all types are requested when any of them is registered.
+func (bot *Bot) AllowedUpdates() []telegram.UpdateType {
+	if len(bot.allowedUpdates) != 0 {
+		return bot.allowedUpdates
+	}
+	if len(bot.updateHandlers) == 0 {
+		return telegram.AllUpdateTypes()
+	}
+	wanted := map[telegram.UpdateType]bool{}
+	for _, registered := range bot.updateHandlers {
+		if registered.updateTypes == nil {
+			return telegram.AllUpdateTypes()
+		}
+		for _, updateType := range registered.updateTypes {
+			wanted[updateType] = true
+		}
+	}
+	var allowed []telegram.UpdateType
+	for _, updateType := range telegram.AllUpdateTypes() {
+		if wanted[updateType] {
+			allowed = append(allowed, updateType)
+		}
+	}
+	return allowed
 }
 
 func (bot *Bot) OnMessage(handler MessageHandler) {
-	bot.updateHandlers = append(bot.updateHandlers, func(services MessageServices, update *telegram.Update) (breakChain bool, err error) {
-		if update.Message == nil {
+	bot.registerFor(handlerName(handler), []telegram.UpdateType{telegram.UpdateTypeMessage}, bot.contextHandler(func(c *Context) (breakChain bool, err error) {
+		if c.Update.Message == nil {
 			return false, nil
 		}
-		return handler(bot, update.Message)
-	})
+		return handler(c, c.Message)
+	}))
 }
 
 func (bot *Bot) OnEditedMessage(handler MessageHandler) {
-	bot.updateHandlers = append(bot.updateHandlers, func(services MessageServices, update *telegram.Update) (breakChain bool, err error) {
-		if update.EditedMessage == nil {
+	bot.registerFor(handlerName(handler), []telegram.UpdateType{telegram.UpdateTypeEditedMessage}, bot.contextHandler(func(c *Context) (breakChain bool, err error) {
+		if c.Update.EditedMessage == nil {
 			return false, nil
 		}
-		return handler(bot, update.Message)
-	})
+		return handler(c, c.Message)
+	}))
 }
 
 func (bot *Bot) OnChannelPost(handler MessageHandler) {
-	bot.updateHandlers = append(bot.updateHandlers, func(services MessageServices, update *telegram.Update) (breakChain bool, err error) {
-		if update.ChannelPost == nil {
+	bot.registerFor(handlerName(handler), []telegram.UpdateType{telegram.UpdateTypeChannelPost}, bot.contextHandler(func(c *Context) (breakChain bool, err error) {
+		if c.Update.ChannelPost == nil {
 			return false, nil
 		}
-		return handler(bot, update.Message)
-	})
+		return handler(c, c.Message)
+	}))
 }
 
 func (bot *Bot) EditedChannelPostMessage(handler MessageHandler) {
-	bot.updateHandlers = append(bot.updateHandlers, func(services MessageServices, update *telegram.Update) (breakChain bool, err error) {
-		if update.EditedChannelPost == nil {
+	bot.registerFor(handlerName(handler), []telegram.UpdateType{telegram.UpdateTypeEditedChannelPost}, bot.contextHandler(func(c *Context) (breakChain bool, err error) {
+		if c.Update.EditedChannelPost == nil {
 			return false, nil
 		}
-		return handler(bot, update.Message)
-	})
+		return handler(c, c.Message)
+	}))
 }
 
 func (bot *Bot) OnInlineQuery(handler InlineQueryHandler) {
-	bot.updateHandlers = append(bot.updateHandlers, func(services MessageServices, update *telegram.Update) (breakChain bool, err error) {
-		if update.InlineQuery == nil {
+	bot.registerFor(handlerName(handler), []telegram.UpdateType{telegram.UpdateTypeInlineResult}, bot.contextHandler(func(c *Context) (breakChain bool, err error) {
+		if c.Update.InlineQuery == nil {
 			return false, nil
 		}
-		return handler(bot, update.InlineQuery)
-	})
+		return handler(c, c.Update.InlineQuery)
+	}))
 }
 
 func (bot *Bot) OnChosenInlineResult(handler ChosenInlineResultHandler) {
-	bot.updateHandlers = append(bot.updateHandlers, func(services MessageServices, update *telegram.Update) (breakChain bool, err error) {
-		if update.ChosenInlineResult == nil {
+	bot.registerFor(handlerName(handler), []telegram.UpdateType{telegram.UpdateTypeChosenInlineResult}, bot.contextHandler(func(c *Context) (breakChain bool, err error) {
+		if c.Update.ChosenInlineResult == nil {
 			return false, nil
 		}
-		return handler(bot, update.ChosenInlineResult)
-	})
+		return handler(c, c.Update.ChosenInlineResult)
+	}))
 }
 
 func (bot *Bot) OnCallbackQuery(handler CallbackQueryHandler) {
-	bot.updateHandlers = append(bot.updateHandlers, func(services MessageServices, update *telegram.Update) (breakChain bool, err error) {
-		if update.CallbackQuery == nil {
+	bot.registerFor(handlerName(handler), []telegram.UpdateType{telegram.UpdateTypeCallbackQuery}, bot.contextHandler(func(c *Context) (breakChain bool, err error) {
+		if c.Update.CallbackQuery == nil {
 			return false, nil
 		}
-		return handler(bot, update.CallbackQuery)
-	})
+		return handler(c, c.Update.CallbackQuery)
+	}))
 }
 
 func (bot *Bot) OnShippingQuery(handler ShippingQueryHandler) {
-	bot.updateHandlers = append(bot.updateHandlers, func(services MessageServices, update *telegram.Update) (breakChain bool, err error) {
-		if update.ShippingQuery == nil {
+	bot.registerFor(handlerName(handler), []telegram.UpdateType{telegram.UpdateTypeShippingQuery}, bot.contextHandler(func(c *Context) (breakChain bool, err error) {
+		if c.Update.ShippingQuery == nil {
 			return false, nil
 		}
-		return handler(bot, update.ShippingQuery)
-	})
+		return handler(c, c.Update.ShippingQuery)
+	}))
 }
 
 func (bot *Bot) OnPreCheckoutQuery(handler PreCheckoutQueryHandler) {
-	bot.updateHandlers = append(bot.updateHandlers, func(services MessageServices, update *telegram.Update) (breakChain bool, err error) {
-		if update.PreCheckoutQuery == nil {
+	bot.registerFor(handlerName(handler), []telegram.UpdateType{telegram.UpdateTypePreCheckoutQuery}, bot.contextHandler(func(c *Context) (breakChain bool, err error) {
+		if c.Update.PreCheckoutQuery == nil {
 			return false, nil
 		}
-		return handler(bot, update.PreCheckoutQuery)
-	})
+		return handler(c, c.Update.PreCheckoutQuery)
+	}))
 }
 
 func (bot *Bot) OnPoll(handler PollHandler) {
-	bot.updateHandlers = append(bot.updateHandlers, func(services MessageServices, update *telegram.Update) (breakChain bool, err error) {
-		if update.Poll == nil {
+	bot.registerFor(handlerName(handler), []telegram.UpdateType{telegram.UpdateTypePoll}, bot.contextHandler(func(c *Context) (breakChain bool, err error) {
+		if c.Update.Poll == nil {
 			return false, nil
 		}
-		return handler(bot, update.Poll)
-	})
+		return handler(c, c.Update.Poll)
+	}))
 }
@@ -0,0 +1,103 @@
+package bot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/alexcom/tba/telegram"
+)
+
+func TestNewBotOpensDefaultStoresOnFirstUse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bot, err := NewBot(Options{WorkingDir: dir, StatusStore: NewMemoryStatusStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{storeFileName, deadLettersFileName} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Fatalf("%s was created before use: %v", name, err)
+		}
+	}
+	if _, err := bot.CallbackData("page", string(make([]byte, MaxCallbackDataSize))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bot.DeadLetters(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{storeFileName, deadLettersFileName} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Fatalf("%s was not created on use: %v", name, err)
+		}
+	}
+	if err := bot.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewBotWithInjectedStoresOwnsNothing(t *testing.T) {
+	bot, err := NewBot(Options{
+		WorkingDir:   "/nonexistent",
+		Store:        NewMemoryKVStore(),
+		SessionStore: NewMemorySessionStore(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bot.ownStores) != 0 {
+		t.Fatalf("bot owns %d stores", len(bot.ownStores))
+	}
+}
+
+func TestAllowedUpdates(t *testing.T) {
+	message := func(MessageServices, *telegram.Message) (bool, error) { return false, nil }
+	query := func(MessageServices, *telegram.CallbackQuery) (bool, error) { return false, nil }
+	tests := []struct {
+		name     string
+		opts     Options
+		register func(bot *Bot)
+		want     []telegram.UpdateType
+	}{
+		{"no handlers", Options{}, func(*Bot) {}, telegram.AllUpdateTypes()},
+		{"typed handlers in canonical order", Options{}, func(bot *Bot) {
+			bot.OnCallbackQuery(query)
+			bot.OnEditedMessage(message)
+			bot.OnMessage(message)
+			bot.OnPoll(func(MessageServices, *telegram.Poll) (bool, error) { return false, nil })
+		}, []telegram.UpdateType{
+			telegram.UpdateTypeMessage, telegram.UpdateTypeEditedMessage, telegram.UpdateTypeCallbackQuery, telegram.UpdateTypePoll,
+		}},
+		{"commands and callbacks", Options{}, func(bot *Bot) {
+			bot.OnCommand("start", noopCommand)
+			bot.OnCallback("vote:<id>", func(MessageServices, *telegram.CallbackQuery, CallbackParams) (bool, error) { return false, nil })
+		}, []telegram.UpdateType{telegram.UpdateTypeMessage, telegram.UpdateTypeCallbackQuery}},
+		{"conversation with own types", Options{}, func(bot *Bot) {
+			bot.OnConversation(Conversation{Name: "c", UpdateTypes: []telegram.UpdateType{telegram.UpdateTypeInlineResult}})
+			bot.OnChannelPost(message)
+		}, []telegram.UpdateType{telegram.UpdateTypeChannelPost, telegram.UpdateTypeInlineResult}},
+		{"untyped handler", Options{}, func(bot *Bot) {
+			bot.OnMessage(message)
+			bot.HandleFunc(func(*Context) (bool, error) { return false, nil })
+		}, telegram.AllUpdateTypes()},
+		{"filtered handler", Options{}, func(bot *Bot) {
+			bot.Handle(IsMessage(), func(*Context) (bool, error) { return false, nil })
+		}, telegram.AllUpdateTypes()},
+		{"options override", Options{AllowedUpdates: []telegram.UpdateType{telegram.UpdateTypePoll}}, func(bot *Bot) {
+			bot.OnMessage(message)
+		}, []telegram.UpdateType{telegram.UpdateTypePoll}},
+	}
+	for _, test := range tests {
+		bot, closeAPI := newFakeAPIBot(t, newFakeAPI(nil), test.opts)
+		test.register(bot)
+		if got := bot.AllowedUpdates(); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: AllowedUpdates() = %v, want %v", test.name, got, test.want)
+		}
+		closeAPI()
+	}
+}
@@ -0,0 +1,288 @@
+package bot
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"github.com/alexcom/tba/telegram"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxCallbackDataSize is limit Telegram puts on callback data of inline keyboard button
+const MaxCallbackDataSize = 64
+
+// CallbackTokenTTL is how long payloads too large for callback data are kept in store
+const CallbackTokenTTL = 30 * 24 * time.Hour
+
+const (
+	callbackSeparator   = ":"
+	callbackTokenMarker = "~"
+	callbackKeyPrefix   = "callback/"
+	// callbackBucket is time span of tokens kept under one key prefix, expired tokens are deleted bucket by bucket
+	callbackBucket = time.Hour
+	// callbackRescanBuckets is how many buckets may expire between prunes before all tokens are listed instead
+	callbackRescanBuckets = 24
+	callbackRandomSize    = 9
+	// callbackTokenSize is marker, bucket as 8 hex digits and base64 of random bytes
+	callbackTokenSize = len(callbackTokenMarker) + 8 + callbackRandomSize/3*4
+)
+
+// CallbackParams are values of callback data matched by pattern placeholders
+type CallbackParams struct {
+	values map[string]string
+}
+
+// String returns value of <name> placeholder, empty if there is none
+func (p CallbackParams) String(name string) string {
+	return p.values[name]
+}
+
+func (p CallbackParams) Int(name string) (int, error) {
+	return strconv.Atoi(p.values[name])
+}
+
+func (p CallbackParams) Int64(name string) (int64, error) {
+	return strconv.ParseInt(p.values[name], 10, 64)
+}
+
+func (p CallbackParams) Bool(name string) (bool, error) {
+	return strconv.ParseBool(p.values[name])
+}
+
+// Rest returns unparsed remainder of data matched by prefix pattern ending with *
+func (p CallbackParams) Rest() string {
+	return p.values["*"]
+}
+
+// CallbackOption configures handler registered with OnCallback or HandleCallback
+type CallbackOption func(options *callbackOptions)
+
+type callbackOptions struct {
+	timeout time.Duration
+}
+
+// CallbackTimeout fails callback handler running longer than timeout like Timeout middleware does,
+// other handlers are not limited. Handler should stop when its Context is done.
+func CallbackTimeout(timeout time.Duration) CallbackOption {
+	return func(options *callbackOptions) {
+		options.timeout = timeout
+	}
+}
+
+type CallbackHandler func(services MessageServices, query *telegram.CallbackQuery, params CallbackParams) (breakChain bool, err error)
+
+// OnCallback registers handler for callback queries which data matches pattern.
+// Pattern is segments separated by colons, either literal or <name> placeholders, e.g. vote:<id>:<choice>.
+// Pattern ending with :* matches any data starting with the segments before it, e.g. menu:*.
+// Data made by CallbackData is decoded before matching, including payloads kept in store.
+func (bot *Bot) OnCallback(pattern string, handler CallbackHandler, opts ...CallbackOption) {
+	bot.handleCallback(pattern, handlerName(handler), func(c *Context) (bool, error) {
+		return handler(c, c.Update.CallbackQuery, c.Params)
+	}, opts)
+}
+
+// HandleCallback registers handler for callback queries like OnCallback does, values are given as Context.Params
+func (bot *Bot) HandleCallback(pattern string, handler ContextHandler, opts ...CallbackOption) {
+	bot.handleCallback(pattern, handlerName(handler), handler, opts)
+}
+
+func (bot *Bot) handleCallback(pattern string, handlerName string, handler ContextHandler, opts []CallbackOption) {
+	options := callbackOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.timeout > 0 {
+		handler = bot.withTimeout(options.timeout, handler)
+	}
+	segments := strings.Split(pattern, callbackSeparator)
+	bot.registerFor(handlerName, []telegram.UpdateType{telegram.UpdateTypeCallbackQuery}, bot.contextHandler(func(c *Context) (breakChain bool, err error) {
+		query := c.Update.CallbackQuery
+		if query == nil {
+			return false, nil
+		}
+		data, found, err := bot.callbacks.decode(query.Data)
+		if err != nil {
+			return false, err
+		}
+		if !found {
+			logrus.Warnf("callback data %q expired", query.Data)
+			return false, nil
+		}
+		params, ok := matchCallback(segments, data)
+		if !ok {
+			return false, nil
+		}
+		callbackContext := *c
+		callbackContext.Params = params
+		return handler(&callbackContext)
+	}))
+}
+
+func matchCallback(pattern []string, data string) (CallbackParams, bool) {
+	params := CallbackParams{values: map[string]string{}}
+	segments := strings.Split(data, callbackSeparator)
+	prefix := len(pattern) > 0 && pattern[len(pattern)-1] == "*"
+	if prefix {
+		pattern = pattern[:len(pattern)-1]
+		if len(segments) < len(pattern) {
+			return params, false
+		}
+		params.values["*"] = strings.Join(segments[len(pattern):], callbackSeparator)
+	} else if len(segments) != len(pattern) {
+		return params, false
+	}
+	for i, p := range pattern {
+		value := unescapeCallbackValue(segments[i])
+		if strings.HasPrefix(p, "<") && strings.HasSuffix(p, ">") {
+			params.values[p[1:len(p)-1]] = value
+		} else if p != value {
+			return params, false
+		}
+	}
+	return params, true
+}
+
+// CallbackData makes callback data for button matched by OnCallback pattern, e.g.
+// CallbackData("vote", 42, "yes") matches vote:<id>:<choice>. Values are formatted with fmt.Sprint
+// and escaped, so they may contain colons. Data longer than 64 bytes is kept in store and replaced with token.
+func (bot *Bot) CallbackData(name string, values ...interface{}) (string, error) {
+	segments := []string{name}
+	for _, value := range values {
+		segments = append(segments, escapeCallbackValue(fmt.Sprint(value)))
+	}
+	return bot.callbacks.encode(strings.Join(segments, callbackSeparator))
+}
+
+var (
+	callbackEscaper   = strings.NewReplacer("%", "%25", callbackSeparator, "%3A")
+	callbackUnescaper = strings.NewReplacer("%25", "%", "%3A", callbackSeparator)
+)
+
+func escapeCallbackValue(value string) string {
+	return callbackEscaper.Replace(value)
+}
+
+// unescapeCallbackValue reverts only escapes CallbackData makes, so data made otherwise may contain % as is
+func unescapeCallbackValue(value string) string {
+	return callbackUnescaper.Replace(value)
+}
+
+// callbackCodec keeps payloads which do not fit callback data in store under tokens.
+// Tokens are kept by hour they were made in, so expired tokens are deleted an hour at a time
+// without listing the rest. Data starting with token marker is always replaced with token,
+// and only data of token format is looked up in store, so other data starting with marker is kept as is.
+type callbackCodec struct {
+	kv KVStore
+	mu sync.Mutex
+	// expired is bucket before which all tokens are deleted, zero until store was pruned
+	expired int64
+}
+
+func newCallbackCodec(kv KVStore) *callbackCodec {
+	return &callbackCodec{kv: kv}
+}
+
+func callbackBucketOf(t time.Time) int64 {
+	return t.Unix() / int64(callbackBucket/time.Second)
+}
+
+func callbackBucketPrefix(bucket int64) string {
+	return fmt.Sprintf("%s%08x/", callbackKeyPrefix, bucket)
+}
+
+func (c *callbackCodec) encode(data string) (string, error) {
+	if len(data) <= MaxCallbackDataSize && !strings.HasPrefix(data, callbackTokenMarker) {
+		return data, nil
+	}
+	random := make([]byte, callbackRandomSize)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	bucket := fmt.Sprintf("%08x", callbackBucketOf(now))
+	id := base64.RawURLEncoding.EncodeToString(random)
+	if err := c.kv.Put(callbackKeyPrefix+bucket+"/"+id, []byte(data)); err != nil {
+		return "", err
+	}
+	c.prune(now)
+	return callbackTokenMarker + bucket + id, nil
+}
+
+// decode returns original data, found is false when data was token which expired
+func (c *callbackCodec) decode(data string) (string, bool, error) {
+	key, ok := callbackTokenKey(data)
+	if !ok {
+		return data, true, nil
+	}
+	value, found, err := c.kv.Get(key)
+	return string(value), found, err
+}
+
+// callbackTokenKey returns store key of token, ok is false if data is not a token
+func callbackTokenKey(data string) (key string, ok bool) {
+	if len(data) != callbackTokenSize || !strings.HasPrefix(data, callbackTokenMarker) {
+		return "", false
+	}
+	token := strings.TrimPrefix(data, callbackTokenMarker)
+	bucket, id := token[:8], token[8:]
+	if _, err := strconv.ParseUint(bucket, 16, 64); err != nil || strings.ToLower(bucket) != bucket {
+		return "", false
+	}
+	if _, err := base64.RawURLEncoding.DecodeString(id); err != nil {
+		return "", false
+	}
+	return callbackKeyPrefix + bucket + "/" + id, true
+}
+
+// prune deletes tokens of buckets which expired since last prune. All tokens are listed only on first prune
+// and after long pause, when deleting bucket by bucket would take longer.
+func (c *callbackCodec) prune(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expired := callbackBucketOf(now.Add(-CallbackTokenTTL))
+	if expired-c.expired > callbackRescanBuckets {
+		c.pruneAll(expired)
+		return
+	}
+	for ; c.expired < expired; c.expired++ {
+		keys, err := c.kv.Keys(callbackBucketPrefix(c.expired))
+		if err != nil {
+			logrus.WithError(err).Warn("listing callback tokens")
+			return
+		}
+		if !c.delete(keys) {
+			return
+		}
+	}
+}
+
+func (c *callbackCodec) pruneAll(expired int64) {
+	keys, err := c.kv.Keys(callbackKeyPrefix)
+	if err != nil {
+		logrus.WithError(err).Warn("listing callback tokens")
+		return
+	}
+	// bucket is fixed width hex, so keys are sorted by time
+	oldest := callbackBucketPrefix(expired)
+	end := 0
+	for end < len(keys) && keys[end] < oldest {
+		end++
+	}
+	if c.delete(keys[:end]) {
+		c.expired = expired
+	}
+}
+
+func (c *callbackCodec) delete(keys []string) bool {
+	for _, key := range keys {
+		if err := c.kv.Delete(key); err != nil {
+			logrus.WithError(err).Warn("deleting expired callback token")
+			return false
+		}
+	}
+	return true
+}
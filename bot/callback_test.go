@@ -0,0 +1,126 @@
+package bot
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatchCallback(t *testing.T) {
+	tests := []struct {
+		pattern string
+		data    string
+		ok      bool
+		params  map[string]string
+	}{
+		{"vote:<id>:<choice>", "vote:42:yes", true, map[string]string{"id": "42", "choice": "yes"}},
+		{"vote:<id>:<choice>", "vote:42", false, nil},
+		{"vote:<id>:<choice>", "vote:42:yes:no", false, nil},
+		{"vote:<id>", "poll:42", false, nil},
+		{"vote:<text>", "vote:a%3Ab%25c", true, map[string]string{"text": "a:b%c"}},
+		{"vote:<text>", "vote:%zz", true, map[string]string{"text": "%zz"}},
+		{"vote:<text>", "vote:100%", true, map[string]string{"text": "100%"}},
+		{"vote:<text>", "vote:%41%3a", true, map[string]string{"text": "%41%3a"}},
+		{"menu:*", "menu:settings:lang", true, map[string]string{"*": "settings:lang"}},
+		{"menu:*", "menu", true, map[string]string{"*": ""}},
+		{"menu:<page>:*", "menu", false, nil},
+	}
+	for _, test := range tests {
+		params, ok := matchCallback(strings.Split(test.pattern, callbackSeparator), test.data)
+		if ok != test.ok {
+			t.Errorf("%s on %q: ok = %v, want %v", test.pattern, test.data, ok, test.ok)
+			continue
+		}
+		for name, want := range test.params {
+			if got := params.values[name]; got != want {
+				t.Errorf("%s on %q: %s = %q, want %q", test.pattern, test.data, name, got, want)
+			}
+		}
+	}
+}
+
+func TestCallbackCodec(t *testing.T) {
+	codec := newCallbackCodec(NewMemoryKVStore())
+	long := "page:" + strings.Repeat("x", MaxCallbackDataSize)
+	for _, data := range []string{"vote:42:yes", long, callbackTokenMarker + "short"} {
+		encoded, err := codec.encode(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(encoded) > MaxCallbackDataSize {
+			t.Errorf("encoded %q is %d bytes long", data, len(encoded))
+		}
+		decoded, found, err := codec.decode(encoded)
+		if err != nil || !found || decoded != data {
+			t.Errorf("decode(encode(%q)) = %q, %v, %v", data, decoded, found, err)
+		}
+	}
+	if encoded, _ := codec.encode("vote:42:yes"); encoded != "vote:42:yes" {
+		t.Errorf("short data was replaced with token %q", encoded)
+	}
+	if _, found, err := codec.decode(callbackTokenMarker + "00000001AAAAAAAAAAAA"); found || err != nil {
+		t.Errorf("unknown token found = %v, err = %v", found, err)
+	}
+	for _, data := range []string{"~", "~short", "~00000001AAAAAAAAAAA", "~0000000gAAAAAAAAAAAA", "~00000001AAAAAAAAAAA%"} {
+		if decoded, found, err := codec.decode(data); decoded != data || !found || err != nil {
+			t.Errorf("decode(%q) = %q, %v, %v, want data as is", data, decoded, found, err)
+		}
+	}
+}
+
+// countingKVStore counts listings of keys
+type countingKVStore struct {
+	KVStore
+	listed int
+}
+
+func (s *countingKVStore) Keys(prefix string) ([]string, error) {
+	s.listed++
+	return s.KVStore.Keys(prefix)
+}
+
+func TestCallbackCodecPrunesExpiredTokens(t *testing.T) {
+	kv := &countingKVStore{KVStore: NewMemoryKVStore()}
+	codec := newCallbackCodec(kv)
+	now := time.Now()
+	tokenAt := func(age time.Duration, id string) string {
+		return callbackBucketPrefix(callbackBucketOf(now.Add(-age))) + id
+	}
+	keys := []string{
+		tokenAt(CallbackTokenTTL+48*time.Hour, "ancient"),
+		tokenAt(CallbackTokenTTL+2*time.Hour, "expired"),
+		tokenAt(CallbackTokenTTL-2*time.Hour, "expiring"),
+		tokenAt(time.Hour, "fresh"),
+	}
+	for _, key := range keys {
+		if err := kv.Put(key, []byte("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertTokens := func(when string, want ...string) {
+		t.Helper()
+		got, _ := kv.KVStore.Keys(callbackKeyPrefix)
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("tokens %s = %v, want %v", when, got, want)
+		}
+	}
+
+	codec.prune(now)
+	assertTokens("after first prune", keys[2], keys[3])
+	if kv.listed != 1 {
+		t.Errorf("first prune listed keys %d times, want once", kv.listed)
+	}
+	for i := 0; i < 100; i++ {
+		codec.prune(now)
+	}
+	if kv.listed != 1 {
+		t.Errorf("prunes within the same hour listed keys %d times, want no more listings", kv.listed-1)
+	}
+
+	kv.listed = 0
+	codec.prune(now.Add(3 * time.Hour))
+	assertTokens("after bucket expired", keys[3])
+	if kv.listed != 3 {
+		t.Errorf("prune 3 hours later listed keys %d times, want once per expired bucket", kv.listed)
+	}
+}
@@ -0,0 +1,277 @@
+package bot
+
+import (
+	"context"
+	"github.com/alexcom/tba/telegram"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf16"
+)
+
+// Command is bot command parsed from message like "/start@OurBot some args"
+type Command struct {
+	// Name is command without leading slash and bot mention
+	Name string
+	// Mention is bot username command was addressed to, empty if there was none
+	Mention string
+	// RawArgs is message text after the command with surrounding spaces trimmed
+	RawArgs string
+	// Args are RawArgs split like shell does: by spaces, with quotes and backslash escapes
+	Args []string
+}
+
+type CommandHandler func(services MessageServices, message *telegram.Message, command Command) (breakChain bool, err error)
+
+// commandInfo describes command in commands menu
+type commandInfo struct {
+	name         string
+	description  string
+	descriptions map[string]string
+	scopes       []telegram.BotCommandScope
+	hidden       bool
+	usage        string
+	roles        []string
+	chatTypes    []string
+	timeout      time.Duration
+}
+
+// CommandOption describes command registered with OnCommand for commands menu
+type CommandOption func(info *commandInfo)
+
+// Description is shown next to command in commands menu
+func Description(description string) CommandOption {
+	return func(info *commandInfo) {
+		info.description = description
+	}
+}
+
+// LocalizedDescription is shown instead of Description to users with given language, e.g. "de"
+func LocalizedDescription(languageCode, description string) CommandOption {
+	return func(info *commandInfo) {
+		if info.descriptions == nil {
+			info.descriptions = map[string]string{}
+		}
+		info.descriptions[languageCode] = description
+	}
+}
+
+// InScopes lists command only in menus of given scopes instead of the default one.
+// Telegram shows users menu of the narrowest scope only, so commands for everyone have to be added to narrower scopes too.
+func InScopes(scopes ...telegram.BotCommandScope) CommandOption {
+	return func(info *commandInfo) {
+		info.scopes = append(info.scopes, scopes...)
+	}
+}
+
+// Usage describes command arguments in help, e.g. "<user> [reason]"
+func Usage(usage string) CommandOption {
+	return func(info *commandInfo) {
+		info.usage = usage
+	}
+}
+
+// RequireRole allows command only to users having any of roles, see Options.Roles.
+// Command is ignored for other users and is not listed in their help. SyncCommands publishes it only
+// to chat, chat member and administrator scopes given with InScopes, never to menus everybody sees.
+func RequireRole(roles ...string) CommandOption {
+	return func(info *commandInfo) {
+		info.roles = append(info.roles, roles...)
+	}
+}
+
+// ForChatTypes lists command in help only in chats of given types, e.g. telegram.ChatTypePrivate
+func ForChatTypes(chatTypes ...string) CommandOption {
+	return func(info *commandInfo) {
+		info.chatTypes = append(info.chatTypes, chatTypes...)
+	}
+}
+
+// Hidden keeps command out of commands menu and help
+func Hidden() CommandOption {
+	return func(info *commandInfo) {
+		info.hidden = true
+	}
+}
+
+// HandlerTimeout fails command handler running longer than timeout like Timeout middleware does,
+// other handlers are not limited. Handler should stop when its Context is done.
+func HandlerTimeout(timeout time.Duration) CommandOption {
+	return func(info *commandInfo) {
+		info.timeout = timeout
+	}
+}
+
+// OnCommand registers handler for messages starting with /name or /name@botname, name is case insensitive.
+// Commands mentioning other bots are ignored, bot username is requested with getMe on first use.
+// Options describe command for commands menu published with SyncCommands.
+func (bot *Bot) OnCommand(name string, handler CommandHandler, opts ...CommandOption) {
+	bot.handleCommand(name, handlerName(handler), func(c *Context) (bool, error) {
+		return handler(c, c.Message, c.Command)
+	}, opts)
+}
+
+// HandleCommand registers handler for command like OnCommand does, command is given as Context.Command
+func (bot *Bot) HandleCommand(name string, handler ContextHandler, opts ...CommandOption) {
+	bot.handleCommand(name, handlerName(handler), handler, opts)
+}
+
+func (bot *Bot) handleCommand(name string, handlerName string, handler ContextHandler, opts []CommandOption) {
+	name = strings.TrimPrefix(name, "/")
+	info := &commandInfo{name: name}
+	for _, opt := range opts {
+		opt(info)
+	}
+	bot.commands = append(bot.commands, info)
+	if info.timeout > 0 {
+		handler = bot.withTimeout(info.timeout, handler)
+	}
+	bot.registerFor(handlerName, []telegram.UpdateType{telegram.UpdateTypeMessage}, bot.contextHandler(func(c *Context) (breakChain bool, err error) {
+		if c.Update.Message == nil {
+			return false, nil
+		}
+		command, ok, err := bot.ownCommand(c, c.Update.Message)
+		if err != nil || !ok || !strings.EqualFold(command.Name, name) {
+			return false, err
+		}
+		if len(info.roles) != 0 {
+			roles, err := bot.callerRoles(c)
+			if err != nil {
+				return false, err
+			}
+			if !info.allowed(roles) {
+				logrus.Infof("command /%s denied to user without required role", name)
+				return false, nil
+			}
+		}
+		commandContext := *c
+		commandContext.Command = command
+		return handler(&commandContext)
+	}))
+}
+
+// ParseCommand extracts command message starts with, ok is false if message is not a command
+func ParseCommand(message *telegram.Message) (command Command, ok bool) {
+	text, entities := message.Text, message.Entities
+	if text == "" {
+		text, entities = message.Caption, message.CaptionEntities
+	}
+	for _, entity := range entities {
+		if entity.Type != telegram.EntityTypeBotCommand || entity.Offset != 0 {
+			continue
+		}
+		end := utf16Index(text, entity.Length)
+		name := strings.TrimPrefix(text[:end], "/")
+		if at := strings.IndexByte(name, '@'); at >= 0 {
+			command.Mention = name[at+1:]
+			name = name[:at]
+		}
+		command.Name = name
+		command.RawArgs = strings.TrimSpace(text[end:])
+		command.Args = SplitArgs(command.RawArgs)
+		return command, true
+	}
+	return command, false
+}
+
+// ownCommand parses command message starts with, ok is false if message is not a command or command mentions other bot
+func (bot *Bot) ownCommand(ctx context.Context, message *telegram.Message) (command Command, ok bool, err error) {
+	command, ok = ParseCommand(message)
+	if !ok || command.Mention == "" {
+		return command, ok, nil
+	}
+	me, err := bot.identity.get(ctx, bot.Telegram)
+	if err != nil {
+		return command, false, err
+	}
+	return command, strings.EqualFold(command.Mention, me.Username), nil
+}
+
+// utf16Index converts offset in UTF-16 code units, which Telegram uses for entities, to byte index in text
+func utf16Index(text string, offset int) int {
+	units := 0
+	for i, r := range text {
+		if units >= offset {
+			return i
+		}
+		units += len(utf16.Encode([]rune{r}))
+	}
+	return len(text)
+}
+
+// SplitArgs splits args by spaces like shell does. Single quotes keep everything literally,
+// double quotes and backslash keep spaces and quotes. Unterminated quote lasts till the end of args.
+func SplitArgs(args string) []string {
+	var result []string
+	var current strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+	for _, r := range args {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\\':
+			escaped = true
+			inArg = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case unicode.IsSpace(r):
+			if inArg {
+				result = append(result, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inArg {
+		result = append(result, current.String())
+	}
+	return result
+}
+
+// identity caches bot user, getMe is repeated until it succeeds.
+// Lock is not held during getMe, so callers waiting for it are bounded by their own ctx
+// and callers coming before the first answer may request it concurrently.
+type identity struct {
+	mu sync.Mutex
+	me *telegram.User
+}
+
+func (i *identity) get(ctx context.Context, client *telegram.BaseClient) (*telegram.User, error) {
+	i.mu.Lock()
+	me := i.me
+	i.mu.Unlock()
+	if me != nil {
+		return me, nil
+	}
+	me, err := client.GetMeContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.me == nil {
+		i.me = me
+	}
+	return i.me, nil
+}
@@ -0,0 +1,138 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alexcom/tba/telegram"
+)
+
+func commandEntity(length int) []telegram.MessageEntity {
+	return []telegram.MessageEntity{{Type: telegram.EntityTypeBotCommand, Offset: 0, Length: length}}
+}
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		message telegram.Message
+		command Command
+		ok      bool
+	}{
+		{
+			name:    "plain",
+			message: telegram.Message{Text: "/start", Entities: commandEntity(6)},
+			command: Command{Name: "start"},
+			ok:      true,
+		},
+		{
+			name:    "mention and args",
+			message: telegram.Message{Text: "/add@MyBot  a 'b c' ", Entities: commandEntity(10)},
+			command: Command{Name: "add", Mention: "MyBot", RawArgs: "a 'b c'", Args: []string{"a", "b c"}},
+			ok:      true,
+		},
+		{
+			name:    "offsets in UTF-16 code units",
+			message: telegram.Message{Text: "/😀x rest", Entities: commandEntity(4)},
+			command: Command{Name: "😀x", RawArgs: "rest", Args: []string{"rest"}},
+			ok:      true,
+		},
+		{
+			name:    "caption",
+			message: telegram.Message{Caption: "/photo cat", CaptionEntities: commandEntity(6)},
+			command: Command{Name: "photo", RawArgs: "cat", Args: []string{"cat"}},
+			ok:      true,
+		},
+		{
+			name: "command not at start",
+			message: telegram.Message{Text: "see /help", Entities: []telegram.MessageEntity{
+				{Type: telegram.EntityTypeBotCommand, Offset: 4, Length: 5},
+			}},
+		},
+		{
+			name:    "no entities",
+			message: telegram.Message{Text: "/start"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			command, ok := ParseCommand(&test.message)
+			if ok != test.ok || !reflect.DeepEqual(command, test.command) {
+				t.Fatalf("got %+v, %v, want %+v, %v", command, ok, test.command, test.ok)
+			}
+		})
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		args string
+		want []string
+	}{
+		{"", nil},
+		{"  a  b\tc ", []string{"a", "b", "c"}},
+		{`'a b' "c d"`, []string{"a b", "c d"}},
+		{`'a\b' "a\"b"`, []string{`a\b`, `a"b`}},
+		{`a\ b`, []string{"a b"}},
+		{`'' ""`, []string{"", ""}},
+		{`x"y z"`, []string{"xy z"}},
+		{`"unterminated quote`, []string{"unterminated quote"}},
+	}
+	for _, test := range tests {
+		if got := SplitArgs(test.args); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("SplitArgs(%q) = %q, want %q", test.args, got, test.want)
+		}
+	}
+}
+
+func TestIdentityDoesNotHoldLockDuringGetMe(t *testing.T) {
+	release := make(chan struct{})
+	var requests int32
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			return
+		}
+		_, _ = fmt.Fprint(w, `{"ok":true,"result":{"id":1,"is_bot":true,"first_name":"Bot","username":"MyBot"}}`)
+	}))
+	defer api.Close()
+	client := telegram.NewClient("token", 0, telegram.WithAPIEndpoint(api.URL))
+	id := &identity{}
+
+	slow := make(chan *telegram.User)
+	go func() {
+		me, _ := id.get(context.Background(), client)
+		slow <- me
+	}()
+	for atomic.LoadInt32(&requests) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := id.get(ctx, client); err == nil {
+		t.Error("getMe succeeded before it was answered")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("caller with 50ms deadline waited %v for getMe in progress", elapsed)
+	}
+
+	close(release)
+	if me := <-slow; me == nil || me.Username != "MyBot" {
+		t.Fatalf("getMe returned %+v", me)
+	}
+	before := atomic.LoadInt32(&requests)
+	if me, err := id.get(context.Background(), client); err != nil || me.Username != "MyBot" {
+		t.Errorf("cached identity = %+v, %v", me, err)
+	}
+	if atomic.LoadInt32(&requests) != before {
+		t.Error("getMe requested again after it succeeded")
+	}
+}
@@ -0,0 +1,175 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"github.com/alexcom/tba/telegram"
+)
+
+var (
+	// ErrNoChat is returned by Context helpers for update which does not come from a chat, e.g. inline query
+	ErrNoChat = errors.New("update has no chat")
+	// ErrNoMessage is returned by Context helpers for update which has no message to reply to, edit or delete
+	ErrNoMessage = errors.New("update has no message")
+	// ErrNoCallbackQuery is returned by Context.Answer for update which is not callback query
+	ErrNoCallbackQuery = errors.New("update is not callback query")
+)
+
+// Context is everything handler needs about update being handled. It is context.Context cancelled
+// when update handling is aborted and MessageServices, so it can be passed to older handlers as is.
+// Handlers registered with HandleFunc, Handle, HandleCommand and HandleCallback get it directly.
+type Context struct {
+	context.Context
+	MessageServices
+	bot    *Bot
+	Update *telegram.Update
+	// Chat is chat update comes from, nil for inline queries and callback queries of inline messages
+	Chat *telegram.Chat
+	// Sender is user who sent message, pressed button or made query, nil for channel posts
+	Sender *telegram.User
+	// Message is message of update: new or edited message or channel post, or message with button pressed
+	Message *telegram.Message
+	// Command is command matched by HandleCommand or OnCommand, zero for other handlers
+	Command Command
+	// Params are callback data values matched by HandleCallback or OnCallback pattern, empty for other handlers
+	Params CallbackParams
+}
+
+type ContextHandler func(c *Context) (breakChain bool, err error)
+
+// HandleFunc registers handler receiving every update as Context
+func (bot *Bot) HandleFunc(handler ContextHandler) {
+	bot.register(handlerName(handler), bot.contextHandler(handler))
+}
+
+// contextHandler adapts ContextHandler to the handler chain
+func (bot *Bot) contextHandler(handler ContextHandler) UpdateHandler {
+	return func(services MessageServices, update *telegram.Update) (breakChain bool, err error) {
+		return handler(bot.newContext(services, update))
+	}
+}
+
+// contextOf returns context of update services are given for
+func contextOf(services MessageServices) context.Context {
+	switch s := services.(type) {
+	case *updateScope:
+		return s.ctx
+	case *Context:
+		return s.Context
+	}
+	return context.Background()
+}
+
+func (bot *Bot) newContext(services MessageServices, update *telegram.Update) *Context {
+	// middleware such as Timeout may pass Context of the update already
+	if c, ok := services.(*Context); ok && c.Update == update {
+		return c
+	}
+	c := &Context{
+		Context:         contextOf(services),
+		MessageServices: services,
+		bot:             bot,
+		Update:          update,
+	}
+	switch {
+	case update.Message != nil:
+		c.Message = update.Message
+	case update.EditedMessage != nil:
+		c.Message = update.EditedMessage
+	case update.ChannelPost != nil:
+		c.Message = update.ChannelPost
+	case update.EditedChannelPost != nil:
+		c.Message = update.EditedChannelPost
+	case update.CallbackQuery != nil:
+		c.Message = update.CallbackQuery.Message
+		c.Sender = update.CallbackQuery.From
+	case update.InlineQuery != nil:
+		c.Sender = update.InlineQuery.From
+	case update.ChosenInlineResult != nil:
+		c.Sender = update.ChosenInlineResult.From
+	case update.ShippingQuery != nil:
+		c.Sender = update.ShippingQuery.From
+	case update.PreCheckoutQuery != nil:
+		c.Sender = update.PreCheckoutQuery.From
+	}
+	if c.Message != nil {
+		c.Chat = c.Message.Chat
+		if c.Sender == nil {
+			c.Sender = c.Message.From
+		}
+	}
+	return c
+}
+
+// Me returns bot user, it is requested with getMe once
+func (c *Context) Me() (*telegram.User, error) {
+	return c.bot.identity.get(c, c.bot.Telegram)
+}
+
+// Session returns session of update, see SessionFrom
+func (c *Context) Session() *Session {
+	return SessionFrom(c.MessageServices)
+}
+
+// Send sends text to the chat update comes from
+func (c *Context) Send(text string) (*telegram.Message, error) {
+	if c.Chat == nil {
+		return nil, ErrNoChat
+	}
+	request := telegram.SendMessageRequest{}
+	request.ChatID = c.Chat.ID
+	request.Text = text
+	return c.bot.Telegram.SendMessageContext(c, request)
+}
+
+// Reply sends text to the chat update comes from as reply to the update message
+func (c *Context) Reply(text string) (*telegram.Message, error) {
+	if c.Chat == nil {
+		return nil, ErrNoChat
+	}
+	if c.Message == nil {
+		return nil, ErrNoMessage
+	}
+	request := telegram.SendMessageRequest{}
+	request.ChatID = c.Chat.ID
+	request.Text = text
+	request.ReplyToMessageID = c.Message.MessageID
+	return c.bot.Telegram.SendMessageContext(c, request)
+}
+
+// Edit replaces text of the update message, e.g. message with button pressed
+func (c *Context) Edit(text string) (*telegram.Message, error) {
+	if c.Chat == nil || c.Message == nil {
+		return nil, ErrNoMessage
+	}
+	request := telegram.EditMessageTextRequest{}
+	request.ChatID = c.Chat.ID
+	request.MessageID = c.Message.MessageID
+	request.Text = text
+	return c.bot.Telegram.EditMessageTextContext(c, request)
+}
+
+// Answer answers callback query, text is shown as notification or as alert if showAlert is set.
+// Every callback query has to be answered, even with empty text, or client keeps showing progress.
+func (c *Context) Answer(text string, showAlert bool) error {
+	if c.Update.CallbackQuery == nil {
+		return ErrNoCallbackQuery
+	}
+	return c.bot.Telegram.AnswerCallbackQueryContext(c, telegram.AnswerCallbackQueryRequest{
+		CallbackQueryID: c.Update.CallbackQuery.ID,
+		Text:            text,
+		ShowAlert:       showAlert,
+	})
+}
+
+// Delete deletes the update message
+func (c *Context) Delete() error {
+	if c.Chat == nil || c.Message == nil {
+		return ErrNoMessage
+	}
+	request := telegram.DeleteMessageRequest{}
+	request.ChatID = c.Chat.ID
+	request.MessageID = c.Message.MessageID
+	_, err := c.bot.Telegram.DeleteMessageContext(c, request)
+	return err
+}
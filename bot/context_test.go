@@ -0,0 +1,86 @@
+package bot
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alexcom/tba/telegram"
+)
+
+func TestEveryHandlerGetsContext(t *testing.T) {
+	api := httptest.NewServer(&fakePolling{})
+	defer api.Close()
+	bot := newConversationBot(t, api)
+	got := map[string]MessageServices{}
+	bot.OnConversation(Conversation{
+		Name:    "register",
+		Command: "register",
+		Start: func(services MessageServices, _ *telegram.Update, _ *ConversationState) (string, error) {
+			got["conversation"] = services
+			return ConversationEnd, nil
+		},
+	})
+	bot.OnCallback("vote:<id>", func(services MessageServices, _ *telegram.CallbackQuery, _ CallbackParams) (bool, error) {
+		got["callback"] = services
+		return false, nil
+	})
+	bot.OnUpdate(func(services MessageServices, _ *telegram.Update) (bool, error) {
+		got["update"] = services
+		return false, nil
+	})
+	bot.processUpdate(context.Background(), commandUpdate(1, 1, "/register", 9))
+	bot.processUpdate(context.Background(), &telegram.Update{UpdateID: 2, CallbackQuery: &telegram.CallbackQuery{
+		Data: "vote:1",
+		From: &telegram.User{ID: 7},
+	}})
+	for _, name := range []string{"conversation", "callback", "update"} {
+		if _, ok := got[name].(*Context); !ok {
+			t.Errorf("%s handler got %T, want *Context", name, got[name])
+		}
+	}
+}
+
+// foreignServices is MessageServices made by custom middleware or dispatcher, not by the bot
+type foreignServices struct {
+	MessageServices
+}
+
+func TestContextHandlersGetCommandAndParams(t *testing.T) {
+	fake := newFakeAPI(nil)
+	bot, closeAPI := newFakeAPIBot(t, fake, Options{})
+	defer closeAPI()
+	bot.Use(func(next UpdateHandler) UpdateHandler {
+		return func(services MessageServices, update *telegram.Update) (bool, error) {
+			return next(foreignServices{services}, update)
+		}
+	})
+	var command Command
+	var params CallbackParams
+	bot.HandleCommand("add", func(c *Context) (bool, error) {
+		command = c.Command
+		return true, nil
+	})
+	bot.HandleCallback("vote:<id>", func(c *Context) (bool, error) {
+		params = c.Params
+		return true, nil
+	})
+	bot.OnHelp(HelpOptions{})
+	bot.processUpdate(context.Background(), commandUpdate(1, 1, "/add@MyBot 1 2", 10))
+	bot.processUpdate(context.Background(), &telegram.Update{UpdateID: 2, CallbackQuery: &telegram.CallbackQuery{
+		Data: "vote:42",
+		From: &telegram.User{ID: 7},
+	}})
+	if failure := bot.processUpdate(context.Background(), commandUpdate(3, 1, "/help", 5)); failure != nil {
+		t.Fatalf("help failed with services made by middleware: %v", failure.err)
+	}
+	if command.Name != "add" || len(command.Args) != 2 {
+		t.Errorf("command = %+v, want add with 2 args", command)
+	}
+	if params.String("id") != "42" {
+		t.Errorf("id = %q, want 42", params.String("id"))
+	}
+	if len(fake.calls("sendMessage")) != 1 {
+		t.Error("help was not sent")
+	}
+}
@@ -0,0 +1,286 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/alexcom/tba/telegram"
+	"strings"
+	"time"
+)
+
+// ConversationScope tells whose replies continue conversation
+type ConversationScope int
+
+const (
+	// ScopeChatUser keeps separate conversation with every user in every chat
+	ScopeChatUser ConversationScope = iota
+	// ScopeChat keeps one conversation per chat, anyone in the chat can continue it
+	ScopeChat
+	// ScopeUser keeps one conversation per user across all chats. Updates of the user from different chats
+	// are then handled one at a time, so they do not race on the same conversation or session.
+	ScopeUser
+)
+
+// ConversationEnd returned by step ends conversation
+const ConversationEnd = ""
+
+const defaultCancelCommand = "cancel"
+
+// StepHandler handles update received in a conversation step and returns name of the next step.
+// Returning the current step repeats it, e.g. after invalid input, returning ConversationEnd ends conversation.
+// Step returning error leaves conversation at the step it was, next is ignored then.
+type StepHandler func(services MessageServices, update *telegram.Update, state *ConversationState) (next string, err error)
+
+// ConversationEndHandler is notified when conversation is cancelled or timed out
+type ConversationEndHandler func(services MessageServices, update *telegram.Update, state *ConversationState) error
+
+// Conversation is multistep dialog, a state machine with a handler for every step
+type Conversation struct {
+	Name string
+	// Command starts conversation, e.g. "register" for /register; conversation may also be started with StartConversation
+	Command string
+	// Start handles update starting conversation and returns the first step
+	Start StepHandler
+	Steps map[string]StepHandler
+	Scope ConversationScope
+	// Timeout ends conversation idle for longer, it is noticed on the next update. Zero means no timeout.
+	Timeout time.Duration
+	// CancelCommand ends conversation, defaults to "cancel"
+	CancelCommand string
+	OnCancel      ConversationEndHandler
+	OnTimeout     ConversationEndHandler
+	// UpdateTypes steps expect, they are added to allowed updates. Defaults to messages and callback queries.
+	UpdateTypes []telegram.UpdateType
+}
+
+// ConversationState is current step of conversation and data collected so far, it is saved after every step
+type ConversationState struct {
+	Conversation string            `json:"conversation"`
+	Step         string            `json:"step"`
+	Data         map[string]string `json:"data,omitempty"`
+	UpdatedAt    time.Time         `json:"updated_at"`
+}
+
+func (s *ConversationState) Get(key string) string {
+	return s.Data[key]
+}
+
+func (s *ConversationState) Set(key, value string) {
+	if s.Data == nil {
+		s.Data = map[string]string{}
+	}
+	s.Data[key] = value
+}
+
+// ConversationStore keeps conversation states under scope keys
+type ConversationStore interface {
+	// Get returns nil if there is no conversation under key
+	Get(key string) (*ConversationState, error)
+	Put(key string, state *ConversationState) error
+	Delete(key string) error
+}
+
+// KVConversationStore keeps conversation states in KVStore
+type KVConversationStore struct {
+	kv KVStore
+}
+
+const conversationKeyPrefix = "conversation/"
+
+func NewKVConversationStore(kv KVStore) *KVConversationStore {
+	return &KVConversationStore{kv: kv}
+}
+
+func (s *KVConversationStore) Get(key string) (*ConversationState, error) {
+	data, found, err := s.kv.Get(conversationKeyPrefix + key)
+	if err != nil || !found {
+		return nil, err
+	}
+	state := &ConversationState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func (s *KVConversationStore) Put(key string, state *ConversationState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return s.kv.Put(conversationKeyPrefix+key, data)
+}
+
+func (s *KVConversationStore) Delete(key string) error {
+	return s.kv.Delete(conversationKeyPrefix + key)
+}
+
+// OnConversation registers conversation. Conversation handlers go before all handlers registered with OnX methods,
+// so replies in active conversation are not seen by other handlers.
+func (bot *Bot) OnConversation(conversation Conversation) {
+	if conversation.CancelCommand == "" {
+		conversation.CancelCommand = defaultCancelCommand
+	}
+	if conversation.UpdateTypes == nil {
+		conversation.UpdateTypes = []telegram.UpdateType{telegram.UpdateTypeMessage, telegram.UpdateTypeCallbackQuery}
+	}
+	bot.conversations = append(bot.conversations, &conversation)
+	handler := bot.contextHandler(func(c *Context) (breakChain bool, err error) {
+		return bot.continueConversation(&conversation, c, c.Update)
+	})
+	bot.updateHandlers = append(bot.updateHandlers, registeredHandler{})
+	copy(bot.updateHandlers[bot.priorityHandlers+1:], bot.updateHandlers[bot.priorityHandlers:])
+	bot.updateHandlers[bot.priorityHandlers] = registeredHandler{
+		name:        "conversation " + conversation.Name,
+		handler:     handler,
+		updateTypes: conversation.UpdateTypes,
+	}
+	bot.priorityHandlers++
+}
+
+// StartConversation starts conversation registered under name for sender of update at given step,
+// replacing any conversation in progress
+func (bot *Bot) StartConversation(name string, update *telegram.Update, step string) error {
+	for _, conversation := range bot.conversations {
+		if conversation.Name == name {
+			if conversation.Steps[step] == nil {
+				return fmt.Errorf("conversation %s has no step %q", name, step)
+			}
+			key, ok := conversationKey(conversation.Scope, update)
+			if !ok {
+				return fmt.Errorf("update %d has no chat or user to start conversation %s with", update.UpdateID, name)
+			}
+			return bot.conversationStore.Put(key, &ConversationState{Conversation: name, Step: step, UpdatedAt: time.Now()})
+		}
+	}
+	return fmt.Errorf("no conversation %s registered", name)
+}
+
+func (bot *Bot) continueConversation(c *Conversation, services MessageServices, update *telegram.Update) (bool, error) {
+	key, ok := conversationKey(c.Scope, update)
+	if !ok {
+		return false, nil
+	}
+	state, err := bot.conversationStore.Get(key)
+	if err != nil {
+		return false, err
+	}
+	if state != nil && state.Conversation != c.Name {
+		state = nil
+	}
+	if state != nil && c.Timeout > 0 && time.Since(state.UpdatedAt) > c.Timeout {
+		if err := bot.conversationStore.Delete(key); err != nil {
+			return false, err
+		}
+		if c.OnTimeout != nil {
+			if err := c.OnTimeout(services, update, state); err != nil {
+				return false, err
+			}
+		}
+		state = nil
+	}
+	command, isCommand := Command{}, false
+	if update.Message != nil {
+		// commands addressed to other bots neither start nor cancel conversation
+		if command, isCommand, err = bot.ownCommand(contextOf(services), update.Message); err != nil {
+			return false, err
+		}
+	}
+	if state != nil && isCommand && strings.EqualFold(command.Name, c.CancelCommand) {
+		if err := bot.conversationStore.Delete(key); err != nil {
+			return false, err
+		}
+		if c.OnCancel != nil {
+			return true, c.OnCancel(services, update, state)
+		}
+		return true, nil
+	}
+	step := c.Start
+	if state != nil {
+		step = c.Steps[state.Step]
+		if step == nil {
+			return false, fmt.Errorf("conversation %s has no step %q", c.Name, state.Step)
+		}
+	} else if c.Command == "" || !isCommand || !strings.EqualFold(command.Name, c.Command) {
+		return false, nil
+	} else {
+		state = &ConversationState{Conversation: c.Name}
+	}
+	next, err := step(services, update, state)
+	if err != nil {
+		// saved state is kept, so the next update reaches the failed step again
+		return true, err
+	}
+	if next == ConversationEnd {
+		return true, bot.conversationStore.Delete(key)
+	}
+	if c.Steps[next] == nil {
+		return true, fmt.Errorf("conversation %s has no step %q to go to", c.Name, next)
+	}
+	state.Step = next
+	state.UpdatedAt = time.Now()
+	return true, bot.conversationStore.Put(key, state)
+}
+
+// userScoped tells whether state of some updates is kept per user across chats, see ScopeUser
+func (bot *Bot) userScoped() bool {
+	if bot.sessionScope == ScopeUser {
+		return true
+	}
+	for _, conversation := range bot.conversations {
+		if conversation.Scope == ScopeUser {
+			return true
+		}
+	}
+	return false
+}
+
+// conversationKey identifies conversation of update sender in scope, ok is false if update has neither chat nor user
+func conversationKey(scope ConversationScope, update *telegram.Update) (string, bool) {
+	chatID, userID := updateOrigin(update)
+	switch scope {
+	case ScopeChat:
+		return fmt.Sprintf("chat/%d", chatID), chatID != 0
+	case ScopeUser:
+		return fmt.Sprintf("user/%d", userID), userID != 0
+	default:
+		return fmt.Sprintf("chat/%d/user/%d", chatID, userID), chatID != 0 || userID != 0
+	}
+}
+
+// updateOrigin returns chat and user update comes from, zero if update has none
+func updateOrigin(update *telegram.Update) (chatID int, userID int) {
+	var message *telegram.Message
+	switch {
+	case update.Message != nil:
+		message = update.Message
+	case update.EditedMessage != nil:
+		message = update.EditedMessage
+	case update.ChannelPost != nil:
+		message = update.ChannelPost
+	case update.EditedChannelPost != nil:
+		message = update.EditedChannelPost
+	case update.CallbackQuery != nil:
+		message = update.CallbackQuery.Message
+		if update.CallbackQuery.From != nil {
+			userID = update.CallbackQuery.From.ID
+		}
+	case update.InlineQuery != nil && update.InlineQuery.From != nil:
+		userID = update.InlineQuery.From.ID
+	case update.ChosenInlineResult != nil && update.ChosenInlineResult.From != nil:
+		userID = update.ChosenInlineResult.From.ID
+	case update.ShippingQuery != nil && update.ShippingQuery.From != nil:
+		userID = update.ShippingQuery.From.ID
+	case update.PreCheckoutQuery != nil && update.PreCheckoutQuery.From != nil:
+		userID = update.PreCheckoutQuery.From.ID
+	}
+	if message != nil {
+		if message.Chat != nil {
+			chatID = message.Chat.ID
+		}
+		if userID == 0 && message.From != nil {
+			userID = message.From.ID
+		}
+	}
+	return chatID, userID
+}
@@ -0,0 +1,167 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alexcom/tba/telegram"
+)
+
+func newConversationBot(t *testing.T, api *httptest.Server) *Bot {
+	bot, err := NewBot(Options{
+		APIEndpoint:  api.URL,
+		Store:        NewMemoryKVStore(),
+		SessionStore: NewMemorySessionStore(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bot.identity.me = &telegram.User{Username: "MyBot"}
+	return bot
+}
+
+func commandUpdate(id int, chatID int, text string, length int) *telegram.Update {
+	message := &telegram.Message{Text: text, Chat: &telegram.Chat{ID: chatID}, From: &telegram.User{ID: 7}}
+	if length > 0 {
+		message.Entities = commandEntity(length)
+	}
+	return &telegram.Update{UpdateID: id, Message: message}
+}
+
+func TestConversationIgnoresCommandsOfOtherBots(t *testing.T) {
+	api := httptest.NewServer(&fakePolling{})
+	defer api.Close()
+	bot := newConversationBot(t, api)
+	started := 0
+	bot.OnConversation(Conversation{
+		Name:    "register",
+		Command: "register",
+		Start: func(MessageServices, *telegram.Update, *ConversationState) (string, error) {
+			started++
+			return "name", nil
+		},
+		Steps: map[string]StepHandler{
+			"name": func(MessageServices, *telegram.Update, *ConversationState) (string, error) {
+				return "name", nil
+			},
+		},
+	})
+	bot.processUpdate(context.Background(), commandUpdate(1, 1, "/register@OtherBot", 18))
+	if started != 0 {
+		t.Fatal("conversation started by command of other bot")
+	}
+	bot.processUpdate(context.Background(), commandUpdate(2, 1, "/register@MyBot", 15))
+	if started != 1 {
+		t.Fatal("conversation not started by own command")
+	}
+	key, _ := conversationKey(ScopeChatUser, commandUpdate(3, 1, "", 0))
+	bot.processUpdate(context.Background(), commandUpdate(3, 1, "/cancel@OtherBot", 16))
+	if state, _ := bot.conversationStore.Get(key); state == nil {
+		t.Fatal("conversation cancelled by command of other bot")
+	}
+	bot.processUpdate(context.Background(), commandUpdate(4, 1, "/cancel", 7))
+	if state, _ := bot.conversationStore.Get(key); state != nil {
+		t.Fatal("conversation not cancelled by own command")
+	}
+}
+
+func TestConversationRejectsUnknownStep(t *testing.T) {
+	api := httptest.NewServer(&fakePolling{})
+	defer api.Close()
+	bot := newConversationBot(t, api)
+	bot.OnConversation(Conversation{
+		Name:    "register",
+		Command: "register",
+		Start: func(MessageServices, *telegram.Update, *ConversationState) (string, error) {
+			return "typo", nil
+		},
+	})
+	update := commandUpdate(1, 1, "/register", 9)
+	if failure := bot.processUpdate(context.Background(), update); failure == nil {
+		t.Fatal("unknown step did not fail")
+	}
+	key, _ := conversationKey(ScopeChatUser, update)
+	if state, _ := bot.conversationStore.Get(key); state != nil {
+		t.Fatalf("unknown step was saved: %+v", state)
+	}
+	if err := bot.StartConversation("register", update, "typo"); err == nil {
+		t.Fatal("StartConversation accepted unknown step")
+	}
+}
+
+func TestUserScopedUpdatesAreSerializedAcrossChats(t *testing.T) {
+	api := httptest.NewServer(&fakePolling{})
+	defer api.Close()
+	bot := newConversationBot(t, api)
+	bot.sessionScope = ScopeUser
+	var mu sync.Mutex
+	running, overlapped := 0, false
+	bot.OnMessage(func(MessageServices, *telegram.Message) (bool, error) {
+		mu.Lock()
+		running++
+		overlapped = overlapped || running > 1
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return false, nil
+	})
+	var wg sync.WaitGroup
+	for chatID := 1; chatID <= 3; chatID++ {
+		wg.Add(1)
+		go func(chatID int) {
+			defer wg.Done()
+			bot.processUpdate(context.Background(), commandUpdate(chatID, chatID, "hello", 0))
+		}(chatID)
+	}
+	wg.Wait()
+	if overlapped {
+		t.Fatal("updates of one user from different chats were handled concurrently")
+	}
+	if len(bot.userLocks.locks) != 0 {
+		t.Fatalf("%d user locks left", len(bot.userLocks.locks))
+	}
+}
+
+func TestConversationFailedStepIsRepeated(t *testing.T) {
+	api := httptest.NewServer(&fakePolling{})
+	defer api.Close()
+	bot := newConversationBot(t, api)
+	var reached []string
+	bot.OnConversation(Conversation{
+		Name:    "register",
+		Command: "register",
+		Start: func(_ MessageServices, _ *telegram.Update, state *ConversationState) (string, error) {
+			state.Set("started", "yes")
+			return "name", nil
+		},
+		Steps: map[string]StepHandler{
+			"name": func(_ MessageServices, update *telegram.Update, state *ConversationState) (string, error) {
+				reached = append(reached, update.Message.Text)
+				if update.Message.Text == "fail" {
+					state.Set("started", "changed")
+					return "", errors.New("storage unavailable")
+				}
+				return ConversationEnd, nil
+			},
+		},
+	})
+	bot.processUpdate(context.Background(), commandUpdate(1, 1, "/register", 9))
+	if failure := bot.processUpdate(context.Background(), commandUpdate(2, 1, "fail", 0)); failure == nil {
+		t.Fatal("failed step was not reported")
+	}
+	key, _ := conversationKey(ScopeChatUser, commandUpdate(3, 1, "", 0))
+	state, _ := bot.conversationStore.Get(key)
+	if state == nil || state.Step != "name" || state.Get("started") != "yes" {
+		t.Fatalf("state after failed step = %+v, want step name with data kept", state)
+	}
+	bot.processUpdate(context.Background(), commandUpdate(3, 1, "Alice", 0))
+	if len(reached) != 2 || reached[1] != "Alice" {
+		t.Fatalf("step reached with %v, want the failed step repeated", reached)
+	}
+}
@@ -0,0 +1,236 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/alexcom/tba/telegram"
+	"github.com/sirupsen/logrus"
+	"reflect"
+	"runtime"
+	"time"
+)
+
+// DeadLetter is update which handler failed, kept until it is retried successfully or purged
+type DeadLetter struct {
+	Update telegram.Update `json:"update"`
+	// Handler is name of the first handler which failed
+	Handler  string    `json:"handler"`
+	Error    string    `json:"error"`
+	FailedAt time.Time `json:"failed_at"`
+	// Attempts counts handling attempts including the original one
+	Attempts int `json:"attempts"`
+}
+
+// DeadLetterStore keeps failed updates, one per update ID
+type DeadLetterStore interface {
+	// Put adds letter or replaces one with the same update ID
+	Put(letter DeadLetter) error
+	Get(updateID int) (letter DeadLetter, found bool, err error)
+	// List returns letters ordered by update ID
+	List() ([]DeadLetter, error)
+	Delete(updateID int) error
+}
+
+// KVDeadLetterStore keeps dead letters in KVStore
+type KVDeadLetterStore struct {
+	kv KVStore
+}
+
+const (
+	deadLetterKeyPrefix = "deadletter/"
+	deadLettersFileName = "dead_letters.log"
+)
+
+func NewKVDeadLetterStore(kv KVStore) *KVDeadLetterStore {
+	return &KVDeadLetterStore{kv: kv}
+}
+
+// deadLetterKey pads update ID so keys are listed in numeric order
+func deadLetterKey(updateID int) string {
+	return fmt.Sprintf("%s%012d", deadLetterKeyPrefix, updateID)
+}
+
+func (s *KVDeadLetterStore) Put(letter DeadLetter) error {
+	data, err := json.Marshal(&letter)
+	if err != nil {
+		return err
+	}
+	return s.kv.Put(deadLetterKey(letter.Update.UpdateID), data)
+}
+
+func (s *KVDeadLetterStore) Get(updateID int) (DeadLetter, bool, error) {
+	letter := DeadLetter{}
+	data, found, err := s.kv.Get(deadLetterKey(updateID))
+	if err != nil || !found {
+		return letter, found, err
+	}
+	err = json.Unmarshal(data, &letter)
+	return letter, err == nil, err
+}
+
+func (s *KVDeadLetterStore) List() ([]DeadLetter, error) {
+	keys, err := s.kv.Keys(deadLetterKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	letters := make([]DeadLetter, 0, len(keys))
+	for _, key := range keys {
+		data, found, err := s.kv.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue
+		}
+		letter := DeadLetter{}
+		if err := json.Unmarshal(data, &letter); err != nil {
+			return nil, err
+		}
+		letters = append(letters, letter)
+	}
+	return letters, nil
+}
+
+func (s *KVDeadLetterStore) Delete(updateID int) error {
+	return s.kv.Delete(deadLetterKey(updateID))
+}
+
+// handlerFailure tells which handler failed handling update
+type handlerFailure struct {
+	handler string
+	err     error
+}
+
+// registeredHandler is handler in the chain together with name used to report its failures
+type registeredHandler struct {
+	name    string
+	handler UpdateHandler
+	// updateTypes handler is interested in, nil means all of them
+	updateTypes []telegram.UpdateType
+}
+
+// handlerName names handler after its function, e.g. main.onStart, or main.main.func1 for closures
+func handlerName(handler interface{}) string {
+	value := reflect.ValueOf(handler)
+	if value.Kind() != reflect.Func || value.IsNil() {
+		return "unknown"
+	}
+	if fn := runtime.FuncForPC(value.Pointer()); fn != nil {
+		return fn.Name()
+	}
+	return "unknown"
+}
+
+// deadLetter stores update failed during first handling
+func (bot *Bot) deadLetter(update *telegram.Update, failure *handlerFailure) {
+	if bot.deadLetters == nil {
+		return
+	}
+	err := bot.deadLetters.Put(DeadLetter{
+		Update:   *update,
+		Handler:  failure.handler,
+		Error:    failure.err.Error(),
+		FailedAt: time.Now(),
+		Attempts: 1,
+	})
+	if err != nil {
+		logrus.WithError(err).Errorf("storing failed update %d to dead letters", update.UpdateID)
+	}
+}
+
+// DeadLetters lists updates whose handlers failed
+func (bot *Bot) DeadLetters() ([]DeadLetter, error) {
+	if bot.deadLetters == nil {
+		return nil, nil
+	}
+	return bot.deadLetters.List()
+}
+
+// RetryDeadLetter passes failed update through the handler chain again.
+// Letter is removed when all handlers succeed, otherwise it is updated with the new failure and returned error.
+func (bot *Bot) RetryDeadLetter(updateID int) error {
+	if bot.deadLetters == nil {
+		return fmt.Errorf("no dead letter for update %d", updateID)
+	}
+	letter, found, err := bot.deadLetters.Get(updateID)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("no dead letter for update %d", updateID)
+	}
+	failure := bot.processUpdate(context.Background(), &letter.Update)
+	if failure == nil {
+		logrus.Infof("dead letter %d retried successfully", updateID)
+		return bot.deadLetters.Delete(updateID)
+	}
+	letter.Handler = failure.handler
+	letter.Error = failure.err.Error()
+	letter.FailedAt = time.Now()
+	letter.Attempts++
+	if err := bot.deadLetters.Put(letter); err != nil {
+		logrus.WithError(err).Errorf("updating dead letter %d", updateID)
+	}
+	return failure.err
+}
+
+// RetryDeadLetters retries every dead letter in update order and returns number of letters which succeeded.
+// Failing letters do not stop retrying the rest.
+func (bot *Bot) RetryDeadLetters() (int, error) {
+	letters, err := bot.DeadLetters()
+	if err != nil {
+		return 0, err
+	}
+	succeeded := 0
+	for _, letter := range letters {
+		if err := bot.RetryDeadLetter(letter.Update.UpdateID); err != nil {
+			logrus.WithError(err).Warnf("dead letter %d failed again", letter.Update.UpdateID)
+			continue
+		}
+		succeeded++
+	}
+	return succeeded, nil
+}
+
+// PurgeDeadLetter drops failed update without handling it
+func (bot *Bot) PurgeDeadLetter(updateID int) error {
+	if bot.deadLetters == nil {
+		return nil
+	}
+	return bot.deadLetters.Delete(updateID)
+}
+
+// PurgeDeadLetters drops all failed updates
+func (bot *Bot) PurgeDeadLetters() error {
+	letters, err := bot.DeadLetters()
+	if err != nil {
+		return err
+	}
+	for _, letter := range letters {
+		if err := bot.deadLetters.Delete(letter.Update.UpdateID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PurgeDeadLettersOlderThan drops updates which failed last more than age ago and returns how many were dropped
+func (bot *Bot) PurgeDeadLettersOlderThan(age time.Duration) (int, error) {
+	letters, err := bot.DeadLetters()
+	if err != nil {
+		return 0, err
+	}
+	oldest := time.Now().Add(-age)
+	purged := 0
+	for _, letter := range letters {
+		if !letter.FailedAt.Before(oldest) {
+			continue
+		}
+		if err := bot.deadLetters.Delete(letter.Update.UpdateID); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
@@ -0,0 +1,97 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexcom/tba/telegram"
+)
+
+// flakyHandler fails while failures are left
+type flakyHandler struct {
+	failures int
+	calls    int
+}
+
+func (h *flakyHandler) handle(c *Context) (bool, error) {
+	h.calls++
+	if h.failures > 0 {
+		h.failures--
+		return false, errors.New("handler is broken")
+	}
+	return false, nil
+}
+
+func TestDeadLetterRetry(t *testing.T) {
+	bot, closeAPI := newFakeAPIBot(t, newFakeAPI(nil), Options{})
+	defer closeAPI()
+	flaky := &flakyHandler{failures: 2}
+	bot.HandleFunc(flaky.handle)
+	update := &telegram.Update{UpdateID: 5, Message: &telegram.Message{Chat: &telegram.Chat{ID: 1}, Text: "hi"}}
+
+	bot.handleUpdate(context.Background(), update)
+	letters, err := bot.DeadLetters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 1 {
+		t.Fatalf("%d dead letters, want 1", len(letters))
+	}
+	letter := letters[0]
+	if letter.Update.UpdateID != 5 || letter.Attempts != 1 || letter.Error != "handler is broken" ||
+		!strings.Contains(letter.Handler, "flakyHandler") || letter.FailedAt.IsZero() {
+		t.Errorf("dead letter %+v", letter)
+	}
+
+	if err := bot.RetryDeadLetter(5); err == nil {
+		t.Fatal("retry of still failing update succeeded")
+	}
+	letters, _ = bot.DeadLetters()
+	if len(letters) != 1 || letters[0].Attempts != 2 {
+		t.Fatalf("dead letters after failed retry %+v, want one with 2 attempts", letters)
+	}
+
+	succeeded, err := bot.RetryDeadLetters()
+	if err != nil || succeeded != 1 {
+		t.Fatalf("RetryDeadLetters = %d, %v, want 1 succeeded", succeeded, err)
+	}
+	if letters, _ = bot.DeadLetters(); len(letters) != 0 {
+		t.Errorf("dead letters after successful retry %+v", letters)
+	}
+	if flaky.calls != 3 {
+		t.Errorf("handler called %d times, want 3", flaky.calls)
+	}
+	if err := bot.RetryDeadLetter(5); err == nil {
+		t.Error("retry of missing dead letter succeeded")
+	}
+}
+
+func TestPurgeDeadLettersOlderThan(t *testing.T) {
+	store := NewKVDeadLetterStore(NewMemoryKVStore())
+	bot, closeAPI := newFakeAPIBot(t, newFakeAPI(nil), Options{DeadLetters: store})
+	defer closeAPI()
+	now := time.Now()
+	for id, age := range map[int]time.Duration{1: 72 * time.Hour, 2: 25 * time.Hour, 3: time.Hour, 4: 0} {
+		err := store.Put(DeadLetter{Update: telegram.Update{UpdateID: id}, FailedAt: now.Add(-age), Attempts: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	purged, err := bot.PurgeDeadLettersOlderThan(24 * time.Hour)
+	if err != nil || purged != 2 {
+		t.Fatalf("PurgeDeadLettersOlderThan = %d, %v, want 2 purged", purged, err)
+	}
+	letters, _ := bot.DeadLetters()
+	if len(letters) != 2 || letters[0].Update.UpdateID != 3 || letters[1].Update.UpdateID != 4 {
+		t.Errorf("dead letters left %+v, want updates 3 and 4", letters)
+	}
+	if err := bot.PurgeDeadLetters(); err != nil {
+		t.Fatal(err)
+	}
+	if letters, _ := bot.DeadLetters(); len(letters) != 0 {
+		t.Errorf("dead letters left after purge %+v", letters)
+	}
+}
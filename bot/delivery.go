@@ -0,0 +1,183 @@
+package bot
+
+import (
+	"container/heap"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DeliveryMode tells when polled update is committed. Polling confirms updates to Telegram as soon as they
+// are fetched, so one slow update does not hold back the others. Until committed, update is kept in journal
+// in Options.Store and replayed from there after crash or shutdown. Committed update is saved to status,
+// journaled updates up to the saved one are not replayed. Webhook updates are not affected: Telegram repeats
+// webhook request until handler returns, so they are always delivered at least once.
+type DeliveryMode int
+
+const (
+	// DeliveryBatch commits update once it and all updates before it were handled, status is saved and
+	// handled updates are removed from journal after every getUpdates call.
+	// Crash replays updates in progress or queued and updates handled since the last call.
+	DeliveryBatch DeliveryMode = iota
+	// DeliveryAtLeastOnce commits update like DeliveryBatch but saves status and removes update from journal
+	// as soon as it is handled. Crash replays only updates in progress or queued. Updates whose handlers
+	// failed are committed as well, they are kept in dead letters.
+	DeliveryAtLeastOnce
+	// DeliveryAtMostOnce commits and saves whole batch before its updates are handled, nothing is journaled.
+	// Updates are never replayed, updates in progress or queued are lost on crash or shutdown.
+	DeliveryAtMostOnce
+)
+
+// Deduplicator recognizes updates received again, e.g. replayed after crash or webhook retried by Telegram
+type Deduplicator interface {
+	// Claim reports whether update should be handled and if so remembers it as in progress, so the same update
+	// is skipped when it arrives again while it is handled or after that. Check and mark are one step,
+	// so of concurrent deliveries of the same update only one is handled.
+	Claim(updateID int) (claimed bool, err error)
+	// MarkHandled remembers claimed update after it was handled
+	MarkHandled(updateID int) error
+}
+
+// NewMemoryDeduplicator remembers last size handled updates, it does not survive restart
+func NewMemoryDeduplicator(size int) Deduplicator {
+	return &memoryDeduplicator{
+		size:       size,
+		seen:       map[int]bool{},
+		inProgress: map[int]bool{},
+	}
+}
+
+type memoryDeduplicator struct {
+	mu         sync.Mutex
+	size       int
+	seen       map[int]bool
+	order      []int
+	inProgress map[int]bool
+}
+
+func (d *memoryDeduplicator) Claim(updateID int) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.seen[updateID] || d.inProgress[updateID] {
+		return false, nil
+	}
+	d.inProgress[updateID] = true
+	return true, nil
+}
+
+func (d *memoryDeduplicator) MarkHandled(updateID int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.inProgress, updateID)
+	if d.seen[updateID] {
+		return nil
+	}
+	d.seen[updateID] = true
+	d.order = append(d.order, updateID)
+	for len(d.order) > d.size {
+		delete(d.seen, d.order[0])
+		d.order = d.order[1:]
+	}
+	return nil
+}
+
+// NewKVDeduplicator remembers handled updates in kv, so replays after restart are recognized.
+// Updates more than retain IDs older than the last handled one are forgotten.
+// Updates in progress are remembered in memory only, so the one handled when process crashed is handled again,
+// and processes sharing kv may handle the same update concurrently.
+func NewKVDeduplicator(kv KVStore, retain int) Deduplicator {
+	return &kvDeduplicator{
+		kv:         kv,
+		retain:     retain,
+		inProgress: map[int]bool{},
+	}
+}
+
+type kvDeduplicator struct {
+	mu         sync.Mutex
+	kv         KVStore
+	retain     int
+	inProgress map[int]bool
+	// handled are IDs of updates remembered in kv, oldest first, so they are forgotten without listing kv
+	handled *updateIDHeap
+}
+
+const dedupKeyPrefix = "dedup/"
+
+// dedupKey pads update ID so keys are listed in numeric order
+func dedupKey(updateID int) string {
+	return fmt.Sprintf("%s%012d", dedupKeyPrefix, updateID)
+}
+
+func (d *kvDeduplicator) Claim(updateID int) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.inProgress[updateID] {
+		return false, nil
+	}
+	_, found, err := d.kv.Get(dedupKey(updateID))
+	if err != nil || found {
+		return false, err
+	}
+	d.inProgress[updateID] = true
+	return true, nil
+}
+
+func (d *kvDeduplicator) MarkHandled(updateID int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.inProgress, updateID)
+	if err := d.load(); err != nil {
+		return err
+	}
+	if err := d.kv.Put(dedupKey(updateID), []byte(strconv.Itoa(updateID))); err != nil {
+		return err
+	}
+	heap.Push(d.handled, updateID)
+	oldest := updateID - d.retain
+	for d.handled.Len() > 0 && (*d.handled)[0] < oldest {
+		if err := d.kv.Delete(dedupKey((*d.handled)[0])); err != nil {
+			return err
+		}
+		heap.Pop(d.handled)
+	}
+	return nil
+}
+
+// load lists updates remembered by previous runs once
+func (d *kvDeduplicator) load() error {
+	if d.handled != nil {
+		return nil
+	}
+	keys, err := d.kv.Keys(dedupKeyPrefix)
+	if err != nil {
+		return err
+	}
+	handled := updateIDHeap{}
+	for _, key := range keys {
+		updateID, err := strconv.Atoi(strings.TrimPrefix(key, dedupKeyPrefix))
+		if err != nil {
+			continue
+		}
+		handled = append(handled, updateID)
+	}
+	heap.Init(&handled)
+	d.handled = &handled
+	return nil
+}
+
+// updateIDHeap is container/heap of update IDs with the lowest first
+type updateIDHeap []int
+
+func (h updateIDHeap) Len() int            { return len(h) }
+func (h updateIDHeap) Less(i, j int) bool  { return h[i] < h[j] }
+func (h updateIDHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *updateIDHeap) Push(x interface{}) { *h = append(*h, x.(int)) }
+
+func (h *updateIDHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,96 @@
+package bot
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestDeduplicatorClaimsUpdateOnce(t *testing.T) {
+	deduplicators := map[string]Deduplicator{
+		"memory": NewMemoryDeduplicator(10),
+		"kv":     NewKVDeduplicator(NewMemoryKVStore(), 10),
+	}
+	for name, dedup := range deduplicators {
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		claims := 0
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				claimed, err := dedup.Claim(1)
+				if err != nil {
+					t.Error(err)
+				}
+				if claimed {
+					mu.Lock()
+					claims++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		if claims != 1 {
+			t.Errorf("%s: concurrent deliveries claimed %d times, want once", name, claims)
+		}
+		if err := dedup.MarkHandled(1); err != nil {
+			t.Fatal(err)
+		}
+		if claimed, _ := dedup.Claim(1); claimed {
+			t.Errorf("%s: handled update claimed again", name)
+		}
+		if claimed, _ := dedup.Claim(2); !claimed {
+			t.Errorf("%s: new update not claimed", name)
+		}
+	}
+}
+
+func TestMemoryDeduplicatorForgetsOldestUpdates(t *testing.T) {
+	dedup := NewMemoryDeduplicator(3)
+	for id := 1; id <= 5; id++ {
+		if claimed, _ := dedup.Claim(id); !claimed {
+			t.Fatalf("update %d not claimed", id)
+		}
+		_ = dedup.MarkHandled(id)
+	}
+	for id, want := range map[int]bool{1: true, 2: true, 3: false, 5: false} {
+		if claimed, _ := dedup.Claim(id); claimed != want {
+			t.Errorf("update %d claimed = %v, want %v", id, claimed, want)
+		}
+	}
+}
+
+func TestKVDeduplicatorForgetsOldUpdatesWithoutListing(t *testing.T) {
+	kv := &countingKVStore{KVStore: NewMemoryKVStore()}
+	dedup := NewKVDeduplicator(kv, 10)
+	for id := 1; id <= 100; id++ {
+		if claimed, err := dedup.Claim(id); !claimed || err != nil {
+			t.Fatalf("update %d claimed = %v, err = %v", id, claimed, err)
+		}
+		if err := dedup.MarkHandled(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if kv.listed != 1 {
+		t.Errorf("keys listed %d times, want once on first use", kv.listed)
+	}
+	keys, _ := kv.KVStore.Keys(dedupKeyPrefix)
+	if len(keys) != 11 || keys[0] != dedupKey(90) {
+		t.Errorf("remembered %v, want updates 90 to 100", keys)
+	}
+
+	// restarted deduplicator finds updates remembered before and forgets them in turn
+	restarted := NewKVDeduplicator(kv, 10)
+	if claimed, _ := restarted.Claim(95); claimed {
+		t.Error("update handled before restart claimed again")
+	}
+	if claimed, _ := restarted.Claim(200); !claimed {
+		t.Fatal("new update not claimed")
+	}
+	if err := restarted.MarkHandled(200); err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ := kv.KVStore.Keys(dedupKeyPrefix); len(keys) != 1 || keys[0] != dedupKey(200) {
+		t.Errorf("remembered %v after restart, want only update 200", keys)
+	}
+}
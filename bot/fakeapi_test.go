@@ -0,0 +1,95 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sync"
+	"testing"
+)
+
+// fakeAPI answers Bot API methods with canned results and records requests, methods without result get true
+type fakeAPI struct {
+	mu       sync.Mutex
+	results  map[string]string
+	requests []fakeRequest
+	// fails tells which requests are answered with failure, Bad Request error by default
+	fails   func(method string, body map[string]interface{}) bool
+	failure string
+}
+
+type fakeRequest struct {
+	method string
+	body   map[string]interface{}
+}
+
+func newFakeAPI(results map[string]string) *fakeAPI {
+	if results == nil {
+		results = map[string]string{}
+	}
+	if _, ok := results["getMe"]; !ok {
+		results["getMe"] = `{"id":1,"is_bot":true,"first_name":"Bot","username":"MyBot"}`
+	}
+	if _, ok := results["sendMessage"]; !ok {
+		results["sendMessage"] = `{"message_id":1,"chat":{"id":1,"type":"private"},"date":0}`
+	}
+	return &fakeAPI{results: results}
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	method := path.Base(r.URL.Path)
+	data, _ := ioutil.ReadAll(r.Body)
+	body := map[string]interface{}{}
+	_ = json.Unmarshal(data, &body)
+	f.mu.Lock()
+	f.requests = append(f.requests, fakeRequest{method: method, body: body})
+	result, ok := f.results[method]
+	failed := f.fails != nil && f.fails(method, body)
+	f.mu.Unlock()
+	if failed {
+		failure := f.failure
+		if failure == "" {
+			failure = `{"ok":false,"error_code":400,"description":"Bad Request: test failure"}`
+		}
+		_, _ = fmt.Fprint(w, failure)
+		return
+	}
+	if !ok {
+		result = "true"
+	}
+	_, _ = fmt.Fprintf(w, `{"ok":true,"result":%s}`, result)
+}
+
+// calls returns bodies of requests made to method
+func (f *fakeAPI) calls(method string) []map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var bodies []map[string]interface{}
+	for _, request := range f.requests {
+		if request.method == method {
+			bodies = append(bodies, request.body)
+		}
+	}
+	return bodies
+}
+
+// newFakeAPIBot makes bot with memory stores talking to fake
+func newFakeAPIBot(t *testing.T, fake *fakeAPI, opts Options) (*Bot, func()) {
+	api := httptest.NewServer(fake)
+	opts.APIEndpoint = api.URL
+	if opts.Store == nil {
+		opts.Store = NewMemoryKVStore()
+	}
+	if opts.SessionStore == nil {
+		opts.SessionStore = NewMemorySessionStore()
+	}
+	bot, err := NewBot(opts)
+	if err != nil {
+		api.Close()
+		t.Fatal(err)
+	}
+	return bot, api.Close
+}
@@ -0,0 +1,210 @@
+package bot
+
+import (
+	"github.com/alexcom/tba/telegram"
+	"regexp"
+	"strings"
+)
+
+// Filter decides whether handler registered with Handle gets update
+type Filter func(c *Context) bool
+
+// Handle registers handler for updates passing filter
+func (bot *Bot) Handle(filter Filter, handler ContextHandler) {
+	bot.register(handlerName(handler), bot.contextHandler(func(c *Context) (breakChain bool, err error) {
+		if !filter(c) {
+			return false, nil
+		}
+		return handler(c)
+	}))
+}
+
+// And passes updates passing all filters
+func And(filters ...Filter) Filter {
+	return func(c *Context) bool {
+		for _, filter := range filters {
+			if !filter(c) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Or passes updates passing any of filters
+func Or(filters ...Filter) Filter {
+	return func(c *Context) bool {
+		for _, filter := range filters {
+			if filter(c) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func Not(filter Filter) Filter {
+	return func(c *Context) bool {
+		return !filter(c)
+	}
+}
+
+// Any passes every update
+func Any() Filter {
+	return func(*Context) bool {
+		return true
+	}
+}
+
+// IsMessage passes new messages, neither edited ones nor channel posts
+func IsMessage() Filter {
+	return func(c *Context) bool {
+		return c.Update.Message != nil
+	}
+}
+
+// IsEdited passes edited messages and channel posts
+func IsEdited() Filter {
+	return func(c *Context) bool {
+		return c.Update.EditedMessage != nil || c.Update.EditedChannelPost != nil
+	}
+}
+
+func IsCallbackQuery() Filter {
+	return func(c *Context) bool {
+		return c.Update.CallbackQuery != nil
+	}
+}
+
+func IsInlineQuery() Filter {
+	return func(c *Context) bool {
+		return c.Update.InlineQuery != nil
+	}
+}
+
+func chatType(types ...string) Filter {
+	return func(c *Context) bool {
+		if c.Chat == nil {
+			return false
+		}
+		for _, t := range types {
+			if c.Chat.Type == t {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func PrivateChat() Filter {
+	return chatType(telegram.ChatTypePrivate)
+}
+
+// Group passes updates from groups and supergroups
+func Group() Filter {
+	return chatType(telegram.ChatTypeGroup, telegram.ChatTypeSupergroup)
+}
+
+func Channel() Filter {
+	return chatType(telegram.ChatTypeChannel)
+}
+
+// ChatIn passes updates from given chats
+func ChatIn(chatIDs ...int) Filter {
+	set := intSet(chatIDs)
+	return func(c *Context) bool {
+		return c.Chat != nil && set[c.Chat.ID]
+	}
+}
+
+// SenderIn passes updates from given users
+func SenderIn(userIDs ...int) Filter {
+	set := intSet(userIDs)
+	return func(c *Context) bool {
+		return c.Sender != nil && set[c.Sender.ID]
+	}
+}
+
+func intSet(values []int) map[int]bool {
+	set := make(map[int]bool, len(values))
+	for _, value := range values {
+		set[value] = true
+	}
+	return set
+}
+
+// HasText passes messages with text, captions are not text
+func HasText() Filter {
+	return func(c *Context) bool {
+		return c.Message != nil && c.Message.Text != ""
+	}
+}
+
+// TextMatches passes messages which text or caption matches re
+func TextMatches(re *regexp.Regexp) Filter {
+	return func(c *Context) bool {
+		if c.Message == nil {
+			return false
+		}
+		text := c.Message.Text
+		if text == "" {
+			text = c.Message.Caption
+		}
+		return re.MatchString(text)
+	}
+}
+
+// IsCommand passes messages with command name, see OnCommand
+func IsCommand(name string) Filter {
+	name = strings.TrimPrefix(name, "/")
+	return func(c *Context) bool {
+		if c.Message == nil {
+			return false
+		}
+		command, ok, err := c.bot.ownCommand(c, c.Message)
+		return err == nil && ok && strings.EqualFold(command.Name, name)
+	}
+}
+
+func HasPhoto() Filter {
+	return func(c *Context) bool {
+		return c.Message != nil && len(c.Message.Photo) > 0
+	}
+}
+
+func HasDocument() Filter {
+	return func(c *Context) bool {
+		return c.Message != nil && c.Message.Document != nil
+	}
+}
+
+func HasLocation() Filter {
+	return func(c *Context) bool {
+		return c.Message != nil && c.Message.Location != nil
+	}
+}
+
+// IsForwarded passes messages forwarded from users, hidden users or channels
+func IsForwarded() Filter {
+	return func(c *Context) bool {
+		return c.Message != nil && c.Message.ForwardDate != 0
+	}
+}
+
+// IsReply passes messages replying to other messages
+func IsReply() Filter {
+	return func(c *Context) bool {
+		return c.Message != nil && c.Message.ReplyToMessage != nil
+	}
+}
+
+// IsReplyToBot passes messages replying to messages of this bot
+func IsReplyToBot() Filter {
+	return func(c *Context) bool {
+		if c.Message == nil || c.Message.ReplyToMessage == nil || c.Message.ReplyToMessage.From == nil {
+			return false
+		}
+		me, err := c.Me()
+		return err == nil && c.Message.ReplyToMessage.From.ID == me.ID
+	}
+}
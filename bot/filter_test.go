@@ -0,0 +1,99 @@
+package bot
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/alexcom/tba/telegram"
+)
+
+func TestFilters(t *testing.T) {
+	bot, closeAPI := newFakeAPIBot(t, newFakeAPI(nil), Options{})
+	defer closeAPI()
+	bot.identity.me = &telegram.User{ID: 100, Username: "MyBot"}
+
+	private := &telegram.Chat{ID: 1, Type: telegram.ChatTypePrivate}
+	group := &telegram.Chat{ID: -2, Type: telegram.ChatTypeSupergroup}
+	user := &telegram.User{ID: 7}
+	text := &telegram.Update{Message: &telegram.Message{Chat: private, From: user, Text: "hello world"}}
+	photo := &telegram.Update{Message: &telegram.Message{Chat: group, From: &telegram.User{ID: 8}, Caption: "cat photo",
+		Photo: []telegram.PhotoSize{{FileID: "p"}}}}
+	edited := &telegram.Update{EditedMessage: &telegram.Message{Chat: private, From: user, Text: "hello again"}}
+	callback := &telegram.Update{CallbackQuery: &telegram.CallbackQuery{ID: "q", From: user,
+		Message: &telegram.Message{Chat: group, From: &telegram.User{ID: 100}}}}
+	inline := &telegram.Update{InlineQuery: &telegram.InlineQuery{ID: "i", From: user, Query: "hello"}}
+	command := &telegram.Update{Message: &telegram.Message{Chat: group, From: user, Text: "/start@MyBot now",
+		Entities: commandEntity(12)}}
+	otherCommand := &telegram.Update{Message: &telegram.Message{Chat: group, From: user, Text: "/start@OtherBot",
+		Entities: commandEntity(15)}}
+	replyToBot := &telegram.Update{Message: &telegram.Message{Chat: group, From: user, Text: "yes",
+		ReplyToMessage: &telegram.Message{From: &telegram.User{ID: 100}}}}
+	hello := regexp.MustCompile(`^(hello|cat)\b`)
+
+	tests := []struct {
+		name   string
+		filter Filter
+		update *telegram.Update
+		want   bool
+	}{
+		{"Any", Any(), inline, true},
+		{"IsMessage text", IsMessage(), text, true},
+		{"IsMessage edited", IsMessage(), edited, false},
+		{"IsEdited", IsEdited(), edited, true},
+		{"IsCallbackQuery", IsCallbackQuery(), callback, true},
+		{"IsInlineQuery message", IsInlineQuery(), text, false},
+		{"PrivateChat", PrivateChat(), text, true},
+		{"PrivateChat group", PrivateChat(), photo, false},
+		{"Group supergroup", Group(), photo, true},
+		{"Group callback message", Group(), callback, true},
+		{"Group inline query", Group(), inline, false},
+		{"ChatIn", ChatIn(5, -2), photo, true},
+		{"ChatIn other chat", ChatIn(5), photo, false},
+		{"SenderIn callback", SenderIn(7), callback, true},
+		{"SenderIn inline", SenderIn(7), inline, true},
+		{"SenderIn other user", SenderIn(7), photo, false},
+		{"HasText", HasText(), text, true},
+		{"HasText caption", HasText(), photo, false},
+		{"TextMatches text", TextMatches(hello), text, true},
+		{"TextMatches caption", TextMatches(hello), photo, true},
+		{"TextMatches inline query", TextMatches(hello), inline, false},
+		{"HasPhoto", HasPhoto(), photo, true},
+		{"IsCommand own", IsCommand("/start"), command, true},
+		{"IsCommand other bot", IsCommand("start"), otherCommand, false},
+		{"IsCommand other name", IsCommand("stop"), command, false},
+		{"IsReplyToBot", IsReplyToBot(), replyToBot, true},
+		{"IsReplyToBot not reply", IsReplyToBot(), text, false},
+		{"And all pass", And(IsMessage(), PrivateChat(), SenderIn(7)), text, true},
+		{"And one fails", And(IsMessage(), Group()), text, false},
+		{"And empty", And(), text, true},
+		{"Or one passes", Or(IsCallbackQuery(), HasPhoto()), photo, true},
+		{"Or none passes", Or(IsCallbackQuery(), HasPhoto()), text, false},
+		{"Or empty", Or(), text, false},
+		{"Not", Not(Group()), text, true},
+		{"nested", And(Or(PrivateChat(), ChatIn(-2)), Not(IsCommand("start")), HasText()), command, false},
+		{"nested passes", And(Or(PrivateChat(), ChatIn(-2)), Not(IsCommand("start")), HasText()), otherCommand, true},
+	}
+	for _, test := range tests {
+		if got := test.filter(bot.newContext(bot, test.update)); got != test.want {
+			t.Errorf("%s = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestHandleCallsHandlerOnlyForPassingUpdates(t *testing.T) {
+	bot, closeAPI := newFakeAPIBot(t, newFakeAPI(nil), Options{})
+	defer closeAPI()
+	var handled []int
+	bot.Handle(And(IsMessage(), ChatIn(1)), func(c *Context) (bool, error) {
+		handled = append(handled, c.Update.UpdateID)
+		return false, nil
+	})
+	for id, chatID := range []int{1, 2, 1} {
+		update := &telegram.Update{UpdateID: id, Message: &telegram.Message{Chat: &telegram.Chat{ID: chatID}}}
+		bot.processUpdate(context.Background(), update)
+	}
+	if len(handled) != 2 || handled[0] != 0 || handled[1] != 2 {
+		t.Errorf("handled updates %v, want 0 and 2", handled)
+	}
+}
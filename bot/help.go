@@ -0,0 +1,130 @@
+package bot
+
+import (
+	"github.com/alexcom/tba/telegram"
+	"html"
+	"strings"
+)
+
+const defaultHelpHeader = "Available commands:"
+
+// RoleFunc returns roles of update sender, e.g. "admin"
+type RoleFunc func(c *Context) (roles []string, err error)
+
+// HelpOptions customize help registered with OnHelp
+type HelpOptions struct {
+	// Command shows help, defaults to "help"
+	Command string
+	// Header goes before command list, defaults to "Available commands:"
+	Header string
+	// LocalizedHeaders replace Header for users with given language
+	LocalizedHeaders map[string]string
+	// CommandOptions describe help command itself, it is described as "Show available commands" by default
+	CommandOptions []CommandOption
+}
+
+// OnHelp registers command replying with HTML list of commands registered with OnCommand.
+// Help lists commands available in the chat type to the caller's roles, with descriptions in caller's language.
+func (bot *Bot) OnHelp(opts HelpOptions) {
+	if opts.Command == "" {
+		opts.Command = "help"
+	}
+	if opts.Header == "" {
+		opts.Header = defaultHelpHeader
+	}
+	commandOpts := append([]CommandOption{Description("Show available commands")}, opts.CommandOptions...)
+	bot.HandleCommand(opts.Command, func(c *Context) (bool, error) {
+		text, err := bot.helpText(c, opts)
+		if err != nil {
+			return true, err
+		}
+		request := telegram.SendMessageRequest{}
+		request.ChatID = c.Chat.ID
+		request.Text = text
+		request.ParseMode = telegram.ParseModeHTML
+		_, err = bot.Telegram.SendMessageContext(c, request)
+		return true, err
+	}, commandOpts...)
+}
+
+func (bot *Bot) helpText(c *Context, opts HelpOptions) (string, error) {
+	language := ""
+	if c.Sender != nil {
+		language = c.Sender.LanguageCode
+	}
+	chatType := ""
+	if c.Chat != nil {
+		chatType = c.Chat.Type
+	}
+	roles, err := bot.callerRoles(c)
+	if err != nil {
+		return "", err
+	}
+	text := strings.Builder{}
+	text.WriteString("<b>")
+	text.WriteString(html.EscapeString(localized(opts.LocalizedHeaders, language, opts.Header)))
+	text.WriteString("</b>\n")
+	for _, info := range bot.commands {
+		if info.hidden || !info.forChatType(chatType) || !info.allowed(roles) {
+			continue
+		}
+		text.WriteString("\n/")
+		text.WriteString(info.name)
+		if info.usage != "" {
+			text.WriteString(" <code>")
+			text.WriteString(html.EscapeString(info.usage))
+			text.WriteString("</code>")
+		}
+		text.WriteString(" — ")
+		text.WriteString(html.EscapeString(localized(info.descriptions, language, info.localizedDescription(""))))
+	}
+	return text.String(), nil
+}
+
+// localized picks value for language like "pt-br" trying "pt-br" and then "pt"
+func localized(values map[string]string, language, fallback string) string {
+	language = strings.ToLower(language)
+	if value := values[language]; value != "" {
+		return value
+	}
+	if dash := strings.IndexByte(language, '-'); dash > 0 {
+		if value := values[language[:dash]]; value != "" {
+			return value
+		}
+	}
+	return fallback
+}
+
+func (bot *Bot) callerRoles(c *Context) ([]string, error) {
+	if bot.roles == nil {
+		return nil, nil
+	}
+	return bot.roles(c)
+}
+
+// allowed reports whether caller with roles may use command
+func (info *commandInfo) allowed(roles []string) bool {
+	if len(info.roles) == 0 {
+		return true
+	}
+	for _, required := range info.roles {
+		for _, role := range roles {
+			if role == required {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (info *commandInfo) forChatType(chatType string) bool {
+	if len(info.chatTypes) == 0 {
+		return true
+	}
+	for _, t := range info.chatTypes {
+		if t == chatType {
+			return true
+		}
+	}
+	return false
+}
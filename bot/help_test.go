@@ -0,0 +1,77 @@
+package bot
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alexcom/tba/telegram"
+)
+
+func TestHelpText(t *testing.T) {
+	tests := []struct {
+		name     string
+		chat     *telegram.Chat
+		sender   *telegram.User
+		wantText string
+	}{
+		{
+			name:   "user in private chat with regional language",
+			chat:   &telegram.Chat{ID: 2, Type: telegram.ChatTypePrivate},
+			sender: &telegram.User{ID: 2, LanguageCode: "de-AT"},
+			wantText: "<b>Befehle:</b>\n" +
+				"\n/start — Starten" +
+				"\n/help — Show available commands",
+		},
+		{
+			name:   "admin in group",
+			chat:   &telegram.Chat{ID: -3, Type: telegram.ChatTypeGroup},
+			sender: &telegram.User{ID: 1, LanguageCode: "en"},
+			wantText: "<b>Commands &amp; usage:</b>\n" +
+				"\n/start — Start" +
+				"\n/ban <code>&lt;user&gt; [reason]</code> — Ban user" +
+				"\n/stats — Group stats" +
+				"\n/help — Show available commands",
+		},
+		{
+			name:   "user in group",
+			chat:   &telegram.Chat{ID: -3, Type: telegram.ChatTypeGroup},
+			sender: &telegram.User{ID: 2},
+			wantText: "<b>Commands &amp; usage:</b>\n" +
+				"\n/start — Start" +
+				"\n/stats — Group stats" +
+				"\n/help — Show available commands",
+		},
+	}
+	for _, test := range tests {
+		fake := newFakeAPI(nil)
+		bot, closeAPI := newFakeAPIBot(t, fake, Options{Roles: func(c *Context) ([]string, error) {
+			if c.Sender != nil && c.Sender.ID == 1 {
+				return []string{"admin"}, nil
+			}
+			return nil, nil
+		}})
+		bot.identity.me = &telegram.User{Username: "MyBot"}
+		bot.OnCommand("start", noopCommand, Description("Start"), LocalizedDescription("de", "Starten"))
+		bot.OnCommand("ban", noopCommand, Description("Ban user"), Usage("<user> [reason]"), RequireRole("admin"))
+		bot.OnCommand("stats", noopCommand, Description("Group stats"), ForChatTypes(telegram.ChatTypeGroup))
+		bot.OnCommand("debug", noopCommand, Description("Debug"), Hidden())
+		bot.OnHelp(HelpOptions{Header: "Commands & usage:", LocalizedHeaders: map[string]string{"de": "Befehle:"}})
+
+		update := &telegram.Update{Message: &telegram.Message{
+			Chat: test.chat, From: test.sender, Text: "/help", Entities: commandEntity(5),
+		}}
+		bot.processUpdate(context.Background(), update)
+		closeAPI()
+		sent := fake.calls("sendMessage")
+		if len(sent) != 1 {
+			t.Errorf("%s: %d messages sent, want help", test.name, len(sent))
+			continue
+		}
+		if text := sent[0]["text"]; text != test.wantText {
+			t.Errorf("%s: help\n%s\nwant\n%s", test.name, text, test.wantText)
+		}
+		if sent[0]["parse_mode"] != string(telegram.ParseModeHTML) || sent[0]["chat_id"] != float64(test.chat.ID) {
+			t.Errorf("%s: help sent as %v", test.name, sent[0])
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/alexcom/tba/telegram"
+)
+
+const journalKeyPrefix = "journal/"
+
+// updateJournal keeps polled updates which are not handled yet. Polling confirms updates to Telegram
+// as soon as they are fetched, so the journal is what replays them after crash or shutdown.
+type updateJournal struct {
+	kv KVStore
+}
+
+func newUpdateJournal(kv KVStore) *updateJournal {
+	return &updateJournal{kv: kv}
+}
+
+// journalKey pads update ID so keys are listed in numeric order
+func journalKey(updateID int) string {
+	return fmt.Sprintf("%s%012d", journalKeyPrefix, updateID)
+}
+
+func (j *updateJournal) put(update *telegram.Update) error {
+	data, err := json.Marshal(update)
+	if err != nil {
+		return err
+	}
+	return j.kv.Put(journalKey(update.UpdateID), data)
+}
+
+func (j *updateJournal) delete(updateID int) error {
+	return j.kv.Delete(journalKey(updateID))
+}
+
+// list returns journaled updates in order they were received
+func (j *updateJournal) list() ([]*telegram.Update, error) {
+	keys, err := j.kv.Keys(journalKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	updates := make([]*telegram.Update, 0, len(keys))
+	for _, key := range keys {
+		data, found, err := j.kv.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue
+		}
+		update := &telegram.Update{}
+		if err := json.Unmarshal(data, update); err != nil {
+			return nil, err
+		}
+		updates = append(updates, update)
+	}
+	return updates, nil
+}
@@ -0,0 +1,265 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/alexcom/tba/telegram"
+	"github.com/sirupsen/logrus"
+	"sort"
+)
+
+const publishedCommandsKey = "commands/published"
+
+// commandMenu is command list shown to users of one scope with one language
+type commandMenu struct {
+	Scope        telegram.BotCommandScope `json:"scope"`
+	LanguageCode string                   `json:"language_code,omitempty"`
+	commands     []telegram.BotCommand
+}
+
+func (m commandMenu) key() string {
+	data, _ := json.Marshal(m)
+	return string(data)
+}
+
+// SyncCommands publishes commands registered with OnCommand as commands menu, a menu for every scope
+// and language commands are described for. Menus published before which have no commands now are deleted.
+// Commands and languages Telegram would reject are skipped with warning. Failing menu does not stop the others,
+// the first error is returned after all menus are tried and menus failed to delete are retried by next sync.
+func (bot *Bot) SyncCommands(ctx context.Context) error {
+	var firstErr error
+	fail := func(err error) {
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	menus := bot.commandMenus()
+	current := map[string]bool{}
+	var published []commandMenu
+	for _, menu := range menus {
+		current[menu.key()] = true
+		scope := menu.Scope
+		_, err := bot.Telegram.SetMyCommandsContext(ctx, telegram.SetMyCommandsRequest{
+			Commands:     menu.commands,
+			Scope:        &scope,
+			LanguageCode: menu.LanguageCode,
+		})
+		if err != nil {
+			logrus.WithError(err).Errorf("publishing commands menu for scope %s language %q", scope.Type, menu.LanguageCode)
+			fail(err)
+		}
+		// menu may be partly published before the error, keep it to be deleted when it is gone
+		published = append(published, menu)
+	}
+	previous, err := bot.publishedMenus()
+	if err != nil {
+		fail(err)
+	}
+	for _, menu := range previous {
+		if current[menu.key()] {
+			continue
+		}
+		scope := menu.Scope
+		_, err := bot.Telegram.DeleteMyCommandsContext(ctx, telegram.DeleteMyCommandsRequest{
+			Scope:        &scope,
+			LanguageCode: menu.LanguageCode,
+		})
+		if err != nil {
+			logrus.WithError(err).Errorf("deleting commands menu for scope %s language %q", scope.Type, menu.LanguageCode)
+			fail(err)
+			published = append(published, menu)
+		}
+	}
+	data, err := json.Marshal(published)
+	if err != nil {
+		fail(err)
+		return firstErr
+	}
+	if err := bot.store.Put(publishedCommandsKey, data); err != nil {
+		fail(err)
+	}
+	return firstErr
+}
+
+func (bot *Bot) syncCommandsOnStart(ctx context.Context) {
+	if !bot.syncCommands {
+		return
+	}
+	if err := bot.SyncCommands(ctx); err != nil {
+		logrus.WithError(err).Error("publishing commands menu")
+	}
+}
+
+func (bot *Bot) publishedMenus() ([]commandMenu, error) {
+	data, found, err := bot.store.Get(publishedCommandsKey)
+	if err != nil || !found {
+		return nil, err
+	}
+	var menus []commandMenu
+	err = json.Unmarshal(data, &menus)
+	return menus, err
+}
+
+// commandMenus groups visible commands by scope, every scope gets menu without language
+// and menu for every language any of its commands has localized description for
+func (bot *Bot) commandMenus() []commandMenu {
+	defaultScope := telegram.BotCommandScope{Type: telegram.BotCommandScopeDefault}
+	menus := map[string]*commandMenu{}
+	var order []string
+	published := bot.publishedCommands()
+	for _, scope := range commandScopes(published) {
+		var commands []*commandInfo
+		languages := map[string]bool{"": true}
+		for _, info := range published {
+			if !info.inScope(scope, defaultScope) {
+				continue
+			}
+			commands = append(commands, info)
+			for language := range info.descriptions {
+				languages[language] = true
+			}
+		}
+		if len(commands) == 0 {
+			continue
+		}
+		for _, language := range sortedLanguages(languages) {
+			menu := &commandMenu{Scope: scope, LanguageCode: language}
+			for _, info := range commands {
+				menu.commands = append(menu.commands, telegram.BotCommand{
+					Command:     info.name,
+					Description: info.localizedDescription(language),
+				})
+			}
+			key := menu.key()
+			if _, found := menus[key]; !found {
+				order = append(order, key)
+			}
+			menus[key] = menu
+		}
+	}
+	result := make([]commandMenu, 0, len(order))
+	for _, key := range order {
+		result = append(result, *menus[key])
+	}
+	return result
+}
+
+// publishedCommands returns commands to list in menus, commands Telegram would reject are dropped with warning
+// and so are localized descriptions of invalid languages. Commands requiring role are kept to restricted scopes.
+func (bot *Bot) publishedCommands() []*commandInfo {
+	var result []*commandInfo
+	for _, info := range bot.commands {
+		if info.hidden {
+			continue
+		}
+		if !validCommandName(info.name) {
+			logrus.Warnf("command /%s is not published, name must be 1-32 characters of a-z, 0-9 and _", info.name)
+			continue
+		}
+		published := *info
+		published.descriptions = map[string]string{}
+		for language, description := range info.descriptions {
+			if !validLanguageCode(language) {
+				logrus.Warnf("description of command /%s for language %q is not published, language must be ISO 639-1 code", info.name, language)
+				continue
+			}
+			published.descriptions[language] = description
+		}
+		if len(info.roles) != 0 {
+			published.scopes = nil
+			for _, scope := range info.scopes {
+				if restrictedScope(scope) {
+					published.scopes = append(published.scopes, scope)
+				}
+			}
+			if len(published.scopes) == 0 {
+				logrus.Warnf("command /%s requires role and is not published, give it chat or administrator scope with InScopes", info.name)
+				continue
+			}
+		}
+		result = append(result, &published)
+	}
+	return result
+}
+
+// commandScopes lists scopes of all commands in order they appear, commands without scopes are in default one
+func commandScopes(commands []*commandInfo) []telegram.BotCommandScope {
+	seen := map[telegram.BotCommandScope]bool{}
+	var scopes []telegram.BotCommandScope
+	add := func(scope telegram.BotCommandScope) {
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	for _, info := range commands {
+		if len(info.scopes) == 0 {
+			add(telegram.BotCommandScope{Type: telegram.BotCommandScopeDefault})
+		}
+		for _, scope := range info.scopes {
+			add(scope)
+		}
+	}
+	return scopes
+}
+
+// restrictedScope tells whether scope is narrow enough for commands requiring role: menu of one chat,
+// one member or chat administrators. Everybody else would see command which ignores them.
+func restrictedScope(scope telegram.BotCommandScope) bool {
+	switch scope.Type {
+	case telegram.BotCommandScopeChat, telegram.BotCommandScopeChatMember,
+		telegram.BotCommandScopeChatAdministrators, telegram.BotCommandScopeAllChatAdministrators:
+		return true
+	}
+	return false
+}
+
+// validCommandName tells whether Telegram accepts name in commands menu
+func validCommandName(name string) bool {
+	if len(name) == 0 || len(name) > 32 {
+		return false
+	}
+	for _, r := range name {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// validLanguageCode tells whether code looks like two-letter ISO 639-1 code
+func validLanguageCode(code string) bool {
+	return len(code) == 2 && code[0] >= 'a' && code[0] <= 'z' && code[1] >= 'a' && code[1] <= 'z'
+}
+
+func (info *commandInfo) inScope(scope, defaultScope telegram.BotCommandScope) bool {
+	if len(info.scopes) == 0 {
+		return scope == defaultScope
+	}
+	for _, s := range info.scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// localizedDescription falls back to description and then to command name, Telegram requires non-empty description
+func (info *commandInfo) localizedDescription(language string) string {
+	if description := info.descriptions[language]; description != "" {
+		return description
+	}
+	if info.description != "" {
+		return info.description
+	}
+	return info.name
+}
+
+func sortedLanguages(languages map[string]bool) []string {
+	result := make([]string, 0, len(languages))
+	for language := range languages {
+		result = append(result, language)
+	}
+	sort.Strings(result)
+	return result
+}
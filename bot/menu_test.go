@@ -0,0 +1,119 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/alexcom/tba/telegram"
+)
+
+func noopCommand(MessageServices, *telegram.Message, Command) (bool, error) {
+	return false, nil
+}
+
+// publishedMenu describes setMyCommands or deleteMyCommands request like "chat:5/de start=Start"
+func publishedMenu(body map[string]interface{}) string {
+	scope, _ := body["scope"].(map[string]interface{})
+	menu := fmt.Sprint(scope["type"])
+	if chatID, ok := scope["chat_id"]; ok {
+		menu += fmt.Sprintf(":%v", chatID)
+	}
+	if language, ok := body["language_code"]; ok {
+		menu += "/" + fmt.Sprint(language)
+	}
+	commands, _ := body["commands"].([]interface{})
+	for _, command := range commands {
+		command := command.(map[string]interface{})
+		menu += fmt.Sprintf(" %v=%v", command["command"], command["description"])
+	}
+	return menu
+}
+
+func publishedMenus(fake *fakeAPI, method string) []string {
+	var menus []string
+	for _, body := range fake.calls(method) {
+		menus = append(menus, publishedMenu(body))
+	}
+	sort.Strings(menus)
+	return menus
+}
+
+func TestSyncCommandsPublishesOnlyValidCommands(t *testing.T) {
+	fake := newFakeAPI(nil)
+	bot, closeAPI := newFakeAPIBot(t, fake, Options{})
+	defer closeAPI()
+	chat := telegram.BotCommandScope{Type: telegram.BotCommandScopeChat, ChatID: 5}
+	admins := telegram.BotCommandScope{Type: telegram.BotCommandScopeAllChatAdministrators}
+	bot.OnCommand("start", noopCommand, Description("Start"),
+		LocalizedDescription("de", "Starten"), LocalizedDescription("english", "Start"), LocalizedDescription("DE", "Starten"))
+	bot.OnCommand("Start_Over", noopCommand, Description("Start over"))
+	bot.OnCommand("bad-name", noopCommand, Description("Bad"))
+	bot.OnCommand(strings.Repeat("a", 33), noopCommand, Description("Long"))
+	bot.OnCommand("secret", noopCommand, Hidden())
+	bot.OnCommand("ban", noopCommand, Description("Ban"), RequireRole("admin"))
+	bot.OnCommand("kick", noopCommand, Description("Kick"), RequireRole("admin"),
+		InScopes(telegram.BotCommandScope{Type: telegram.BotCommandScopeDefault}, chat, admins))
+	if err := bot.SyncCommands(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"all_chat_administrators kick=Kick",
+		"chat:5 kick=Kick",
+		"default start=Start",
+		"default/de start=Starten",
+	}
+	if got := publishedMenus(fake, "setMyCommands"); !reflect.DeepEqual(got, want) {
+		t.Errorf("published menus\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestSyncCommandsGoesOnAfterFailedMenu(t *testing.T) {
+	store := NewMemoryKVStore()
+	chat := func(id int) telegram.BotCommandScope {
+		return telegram.BotCommandScope{Type: telegram.BotCommandScopeChat, ChatID: id}
+	}
+	first := newFakeAPI(nil)
+	bot, closeAPI := newFakeAPIBot(t, first, Options{Store: store})
+	bot.OnCommand("start", noopCommand, Description("Start"))
+	bot.OnCommand("stats", noopCommand, Description("Stats"), InScopes(chat(5)))
+	err := bot.SyncCommands(context.Background())
+	closeAPI()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second := newFakeAPI(nil)
+	second.fails = func(method string, body map[string]interface{}) bool {
+		menu := publishedMenu(body)
+		return method == "setMyCommands" && strings.HasPrefix(menu, "chat:7") ||
+			method == "deleteMyCommands" && menu == "default"
+	}
+	bot, closeAPI = newFakeAPIBot(t, second, Options{Store: store})
+	bot.OnCommand("stats", noopCommand, Description("Stats"), InScopes(chat(6)))
+	bot.OnCommand("report", noopCommand, Description("Report"), InScopes(chat(7)))
+	err = bot.SyncCommands(context.Background())
+	closeAPI()
+	if err == nil {
+		t.Fatal("failed menus are not reported")
+	}
+	if got, want := publishedMenus(second, "setMyCommands"), []string{"chat:6 stats=Stats", "chat:7 report=Report"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("published menus %q, want %q", got, want)
+	}
+	if got, want := publishedMenus(second, "deleteMyCommands"), []string{"chat:5", "default"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("deleted menus %q, want %q", got, want)
+	}
+
+	third := newFakeAPI(nil)
+	bot, closeAPI = newFakeAPIBot(t, third, Options{Store: store})
+	defer closeAPI()
+	if err := bot.SyncCommands(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := publishedMenus(third, "deleteMyCommands"), []string{"chat:6", "chat:7", "default"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("menus deleted by next sync %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,149 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/alexcom/tba/telegram"
+	"github.com/sirupsen/logrus"
+	"runtime/debug"
+	"time"
+)
+
+// Middleware wraps handler with behaviour common to all handlers, same way as net/http middleware does
+type Middleware func(next UpdateHandler) UpdateHandler
+
+// Use adds middlewares wrapping every registered handler, including ones registered before.
+// The first middleware is the outermost one. Panics are always recovered, both in handlers and middlewares.
+func (bot *Bot) Use(middlewares ...Middleware) {
+	bot.middlewares = append(bot.middlewares, middlewares...)
+}
+
+// wrap applies middlewares to handler, Recover goes both inside so middlewares see handler panics as errors
+// and outside so panicking middleware does not crash the bot
+func (bot *Bot) wrap(handler UpdateHandler) UpdateHandler {
+	wrapped := Recover()(handler)
+	for i := len(bot.middlewares) - 1; i >= 0; i-- {
+		wrapped = bot.middlewares[i](wrapped)
+	}
+	return Recover()(wrapped)
+}
+
+// PanicError is returned by Recover for handler which panicked
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("handler panicked: %v", e.Value)
+}
+
+// Recover turns handler panic into *PanicError, it is installed by default
+func Recover() Middleware {
+	return func(next UpdateHandler) UpdateHandler {
+		return func(services MessageServices, update *telegram.Update) (breakChain bool, err error) {
+			defer func() {
+				if value := recover(); value != nil {
+					panicErr := &PanicError{Value: value, Stack: debug.Stack()}
+					logrus.WithField("update_id", update.UpdateID).Errorf("%s\n%s", panicErr, panicErr.Stack)
+					err = panicErr
+				}
+			}()
+			return next(services, update)
+		}
+	}
+}
+
+// ErrHandlerTimeout is returned by Timeout for handler which did not finish in time
+var ErrHandlerTimeout = errors.New("handler timed out")
+
+// Timeout fails handler running longer than timeout with ErrHandlerTimeout and stops the chain.
+// Handler is given Context cancelled on timeout, handler ignoring it keeps running in background,
+// session changes it makes after that are saved immediately. Without timeout handler which never returns
+// holds later updates of its chat forever, see Options.Workers. HandlerTimeout and CallbackTimeout
+// limit single handler instead of all of them.
+func Timeout(timeout time.Duration) Middleware {
+	return func(next UpdateHandler) UpdateHandler {
+		return func(services MessageServices, update *telegram.Update) (bool, error) {
+			ctx, cancel := context.WithTimeout(contextOf(services), timeout)
+			defer cancel()
+			type result struct {
+				breakChain bool
+				err        error
+				panicValue interface{}
+			}
+			done := make(chan result, 1)
+			go func() {
+				defer func() {
+					if value := recover(); value != nil {
+						done <- result{panicValue: value}
+					}
+				}()
+				breakChain, err := next(withContext(services, ctx), update)
+				done <- result{breakChain: breakChain, err: err}
+			}()
+			select {
+			case r := <-done:
+				if r.panicValue != nil {
+					// repanic in the calling goroutine so outer Recover handles it
+					panic(r.panicValue)
+				}
+				return r.breakChain, r.err
+			case <-ctx.Done():
+				if ctx.Err() == context.Canceled {
+					// update handling was aborted, e.g. on shutdown
+					return true, ctx.Err()
+				}
+				return true, ErrHandlerTimeout
+			}
+		}
+	}
+}
+
+// withTimeout limits handler like Timeout does, Context given to handler keeps its Command and Params
+func (bot *Bot) withTimeout(timeout time.Duration, handler ContextHandler) ContextHandler {
+	limited := Timeout(timeout)(func(services MessageServices, update *telegram.Update) (bool, error) {
+		return handler(bot.newContext(services, update))
+	})
+	return func(c *Context) (bool, error) {
+		return limited(c, c.Update)
+	}
+}
+
+// Logging logs every handler call with its duration and outcome
+func Logging() Middleware {
+	return func(next UpdateHandler) UpdateHandler {
+		return func(services MessageServices, update *telegram.Update) (bool, error) {
+			start := time.Now()
+			breakChain, err := next(services, update)
+			entry := logrus.WithFields(logrus.Fields{
+				"update_id":   update.UpdateID,
+				"duration":    time.Since(start),
+				"break_chain": breakChain,
+			})
+			if err != nil {
+				entry.WithError(err).Warn("handler failed")
+			} else {
+				entry.Info("handler done")
+			}
+			return breakChain, err
+		}
+	}
+}
+
+// ErrorReporter receives handler errors, e.g. to send them to error tracking service
+type ErrorReporter func(update *telegram.Update, err error)
+
+// ReportErrors passes every handler error, including recovered panics, to report
+func ReportErrors(report ErrorReporter) Middleware {
+	return func(next UpdateHandler) UpdateHandler {
+		return func(services MessageServices, update *telegram.Update) (bool, error) {
+			breakChain, err := next(services, update)
+			if err != nil {
+				report(update, err)
+			}
+			return breakChain, err
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package bot
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alexcom/tba/telegram"
+)
+
+func TestTimeoutCancelsHandlerAndStopsChain(t *testing.T) {
+	api := httptest.NewServer(&fakePolling{})
+	defer api.Close()
+	sessions := NewMemorySessionStore()
+	bot, err := NewBot(Options{
+		APIEndpoint:  api.URL,
+		Store:        NewMemoryKVStore(),
+		StatusStore:  NewMemoryStatusStore(),
+		SessionStore: sessions,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	update := &telegram.Update{UpdateID: 1, Message: &telegram.Message{
+		Chat: &telegram.Chat{ID: 1},
+		From: &telegram.User{ID: 2},
+	}}
+	scope := newUpdateScope(context.Background(), bot, update)
+	abandoned := make(chan struct{})
+	handlerDone := make(chan struct{})
+	handler := Timeout(10 * time.Millisecond)(func(services MessageServices, update *telegram.Update) (bool, error) {
+		defer close(handlerDone)
+		c, ok := services.(*Context)
+		if !ok {
+			t.Errorf("handler got %T, want *Context", services)
+			return false, nil
+		}
+		<-c.Done()
+		<-abandoned
+		SessionFrom(c).Set("late", "saved")
+		return false, nil
+	})
+	breakChain, err := handler(scope, update)
+	if err != ErrHandlerTimeout || !breakChain {
+		t.Fatalf("got %v, %v, want true, ErrHandlerTimeout", breakChain, err)
+	}
+	scope.close()
+	close(abandoned)
+	<-handlerDone
+	values, found, _ := sessions.Load(SessionFrom(scope).Key())
+	if !found || values["late"] != "saved" {
+		t.Fatalf("session change made after close was not saved: %v", values)
+	}
+}
+
+func TestHandlerTimeoutLimitsRegisteredHandler(t *testing.T) {
+	bot, closeAPI := newFakeAPIBot(t, newFakeAPI(nil), Options{})
+	defer closeAPI()
+	bot.identity.me = &telegram.User{Username: "MyBot"}
+	stopped := make(chan string, 2)
+	slow := func(c *Context) (bool, error) {
+		<-c.Done()
+		stopped <- c.Command.Name + c.Params.String("id")
+		return false, nil
+	}
+	bot.HandleCommand("slow", slow, HandlerTimeout(20*time.Millisecond))
+	bot.HandleCallback("vote:<id>", slow, CallbackTimeout(20*time.Millisecond))
+	bot.HandleCommand("fast", func(c *Context) (bool, error) {
+		return true, nil
+	}, HandlerTimeout(time.Second))
+
+	command := func(text string) *telegram.Update {
+		return &telegram.Update{Message: &telegram.Message{
+			Chat: &telegram.Chat{ID: 1}, Text: text, Entities: commandEntity(len(text)),
+		}}
+	}
+	callback := &telegram.Update{CallbackQuery: &telegram.CallbackQuery{ID: "q", Data: "vote:42"}}
+	for _, update := range []*telegram.Update{command("/slow"), callback} {
+		failure := bot.processUpdate(context.Background(), update)
+		if failure == nil || failure.err != ErrHandlerTimeout {
+			t.Errorf("update %+v failed with %+v, want timeout", update, failure)
+		}
+	}
+	if failure := bot.processUpdate(context.Background(), command("/fast")); failure != nil {
+		t.Errorf("fast handler failed with %v", failure.err)
+	}
+	// abandoned handlers see cancelled Context with their command and params
+	for _, want := range []string{"slow", "42"} {
+		select {
+		case got := <-stopped:
+			if got != want {
+				t.Errorf("stopped handler got %q, want %q", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("handler context not cancelled")
+		}
+	}
+}
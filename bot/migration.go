@@ -0,0 +1,83 @@
+package bot
+
+import (
+	"github.com/alexcom/tba/telegram"
+	"github.com/sirupsen/logrus"
+	"sync"
+)
+
+// ChatMigration is emitted once group is upgraded to supergroup and gets new chat ID.
+// Anything storing chat IDs, e.g. allowlists, should replace FromChatID with ToChatID.
+type ChatMigration struct {
+	FromChatID int
+	ToChatID   int
+}
+
+type ChatMigrationHandler func(migration ChatMigration)
+
+// maxSeenMigrations is how many recent migrations are remembered to drop repeated reports,
+// the same migration is reported by both service messages and failed requests shortly after it happens
+const maxSeenMigrations = 1000
+
+// migrations delivers every migration to subscribers once, no matter how many times it was reported
+type migrations struct {
+	mu       sync.Mutex
+	seen     map[ChatMigration]bool
+	order    []ChatMigration
+	handlers []ChatMigrationHandler
+}
+
+func newMigrations() *migrations {
+	return &migrations{
+		seen: map[ChatMigration]bool{},
+	}
+}
+
+func (m *migrations) subscribe(handler ChatMigrationHandler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handlers = append(m.handlers, handler)
+}
+
+func (m *migrations) emit(migration ChatMigration) {
+	m.mu.Lock()
+	if m.seen[migration] {
+		m.mu.Unlock()
+		return
+	}
+	m.seen[migration] = true
+	m.order = append(m.order, migration)
+	if len(m.order) > maxSeenMigrations {
+		delete(m.seen, m.order[0])
+		m.order = m.order[1:]
+	}
+	handlers := append([]ChatMigrationHandler(nil), m.handlers...)
+	m.mu.Unlock()
+
+	logrus.Infof("chat %d migrated to supergroup %d", migration.FromChatID, migration.ToChatID)
+	for _, handler := range handlers {
+		handler(migration)
+	}
+}
+
+// emitFromUpdate checks update for migration service messages,
+// they are sent both to the old group and to the new supergroup
+func (m *migrations) emitFromUpdate(update *telegram.Update) {
+	message := update.Message
+	if message == nil || message.Chat == nil {
+		return
+	}
+	if message.MigrateToChatID != 0 {
+		m.emit(ChatMigration{FromChatID: message.Chat.ID, ToChatID: message.MigrateToChatID})
+	}
+	if message.MigrateFromChatID != 0 {
+		m.emit(ChatMigration{FromChatID: message.MigrateFromChatID, ToChatID: message.Chat.ID})
+	}
+}
+
+// OnChatMigration subscribes handler to group to supergroup migrations
+// reported either by incoming updates or by failed requests.
+// Handler given with telegram.WithMigrationHandler in Options.ClientOptions is called as well.
+func (bot *Bot) OnChatMigration(handler ChatMigrationHandler) {
+	bot.migrations.subscribe(handler)
+}
@@ -0,0 +1,67 @@
+package bot
+
+import (
+	"testing"
+
+	"github.com/alexcom/tba/telegram"
+)
+
+func TestMigrationReachesClientOptionAndBotHandlers(t *testing.T) {
+	fake := newFakeAPI(nil)
+	fake.fails = func(method string, body map[string]interface{}) bool {
+		return method == "sendMessage"
+	}
+	fake.failure = `{"ok":false,"error_code":400,"description":"Bad Request: group chat was upgraded to a supergroup chat","parameters":{"migrate_to_chat_id":-1002}}`
+	var clientMigrations, botMigrations []ChatMigration
+	bot, closeAPI := newFakeAPIBot(t, fake, Options{ClientOptions: []telegram.ClientOption{
+		telegram.WithMigrationHandler(func(fromChatID, toChatID int) {
+			clientMigrations = append(clientMigrations, ChatMigration{FromChatID: fromChatID, ToChatID: toChatID})
+		}),
+	}})
+	defer closeAPI()
+	bot.OnChatMigration(func(migration ChatMigration) {
+		botMigrations = append(botMigrations, migration)
+	})
+
+	request := telegram.SendMessageRequest{}
+	request.ChatID = -1
+	request.Text = "hello"
+	if _, err := bot.Telegram.SendMessage(request); err == nil {
+		t.Fatal("request to migrated chat succeeded")
+	}
+	// the same migration reported by service message is not delivered again
+	bot.migrations.emitFromUpdate(&telegram.Update{Message: &telegram.Message{
+		Chat:              &telegram.Chat{ID: -1002},
+		MigrateFromChatID: -1,
+	}})
+
+	want := ChatMigration{FromChatID: -1, ToChatID: -1002}
+	if len(clientMigrations) != 1 || clientMigrations[0] != want {
+		t.Errorf("client option handler got %v, want %v", clientMigrations, want)
+	}
+	if len(botMigrations) != 1 || botMigrations[0] != want {
+		t.Errorf("OnChatMigration handler got %v, want %v", botMigrations, want)
+	}
+}
+
+func TestMigrationsRememberRecentOnly(t *testing.T) {
+	m := newMigrations()
+	emitted := 0
+	m.subscribe(func(ChatMigration) {
+		emitted++
+	})
+	for i := 0; i <= maxSeenMigrations; i++ {
+		m.emit(ChatMigration{FromChatID: -i, ToChatID: -1000 - i})
+	}
+	if len(m.seen) != maxSeenMigrations || len(m.order) != maxSeenMigrations {
+		t.Errorf("remembered %d migrations, want %d", len(m.seen), maxSeenMigrations)
+	}
+	m.emit(ChatMigration{FromChatID: -maxSeenMigrations, ToChatID: -1000 - maxSeenMigrations})
+	if emitted != maxSeenMigrations+1 {
+		t.Errorf("recent migration emitted again")
+	}
+	m.emit(ChatMigration{FromChatID: 0, ToChatID: -1000})
+	if emitted != maxSeenMigrations+2 {
+		t.Errorf("forgotten migration not emitted again")
+	}
+}
@@ -0,0 +1,323 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/alexcom/tba/telegram"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const sessionsDirName = "sessions"
+
+// SessionStore keeps session values under keys made of chat and user.
+// Sessions not saved for longer than ttl are expired, zero ttl means session never expires.
+type SessionStore interface {
+	// Load returns found false if there is no session under key or it expired
+	Load(key string) (values map[string]string, found bool, err error)
+	Save(key string, values map[string]string, ttl time.Duration) error
+	Delete(key string) error
+}
+
+// Session is state of chat and user kept between updates, it is loaded on first use and saved after handlers are done.
+// Changes made after that, e.g. by handler abandoned by Timeout, are saved immediately.
+type Session struct {
+	mu      sync.Mutex
+	key     string
+	values  map[string]string
+	changed bool
+	closed  bool
+	store   SessionStore
+	ttl     time.Duration
+}
+
+func (s *Session) Get(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values[key]
+}
+
+func (s *Session) Set(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+	s.changedLocked()
+}
+
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, key)
+	s.changedLocked()
+}
+
+// Clear removes all values, session is deleted from store
+func (s *Session) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values = map[string]string{}
+	s.changedLocked()
+}
+
+func (s *Session) changedLocked() {
+	s.changed = true
+	if s.closed {
+		s.saveLocked()
+	}
+}
+
+// saveLocked saves session if it changed, unchanged session is saved too when it has TTL to prolong it
+func (s *Session) saveLocked() {
+	var err error
+	switch {
+	case s.changed && len(s.values) == 0:
+		err = s.store.Delete(s.key)
+	case s.changed || s.ttl > 0:
+		err = s.store.Save(s.key, s.values, s.ttl)
+	}
+	if err != nil {
+		logrus.WithError(err).Errorf("saving session %s", s.key)
+	}
+	s.changed = false
+}
+
+// Key identifies session in store
+func (s *Session) Key() string {
+	return s.key
+}
+
+// SessionFrom returns session of update being handled, services must be the one passed to handler.
+// Returns nil if update has neither chat nor user.
+func SessionFrom(services MessageServices) *Session {
+	switch s := services.(type) {
+	case *updateScope:
+		return s.session()
+	case *Context:
+		return SessionFrom(s.MessageServices)
+	}
+	return nil
+}
+
+// updateScope is MessageServices passed to handlers of single update, it carries state attached to the update
+type updateScope struct {
+	*Bot
+	ctx    context.Context
+	update *telegram.Update
+	state  *scopeState
+}
+
+// scopeState is shared by copies of updateScope made for derived contexts
+type scopeState struct {
+	// mu guards session, handler abandoned by Timeout may still use it
+	mu            sync.Mutex
+	sessionLoaded bool
+	sessionValue  *Session
+	closed        bool
+}
+
+func newUpdateScope(ctx context.Context, bot *Bot, update *telegram.Update) *updateScope {
+	return &updateScope{Bot: bot, ctx: ctx, update: update, state: &scopeState{}}
+}
+
+// withContext returns services of the same update carrying ctx, handlers given them see ctx as Context.
+// Services not made by the bot are returned as is.
+func withContext(services MessageServices, ctx context.Context) MessageServices {
+	switch s := services.(type) {
+	case *updateScope:
+		scope := *s
+		scope.ctx = ctx
+		return scope.Bot.newContext(&scope, scope.update)
+	case *Context:
+		c := *s
+		c.Context = ctx
+		if scope, ok := withContext(s.MessageServices, ctx).(*Context); ok {
+			c.MessageServices = scope.MessageServices
+		}
+		return &c
+	}
+	return services
+}
+
+func (u *updateScope) session() *Session {
+	state := u.state
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if state.sessionLoaded {
+		return state.sessionValue
+	}
+	state.sessionLoaded = true
+	key, ok := conversationKey(u.sessionScope, u.update)
+	if !ok {
+		return nil
+	}
+	values, found, err := u.sessionStore.Load(key)
+	if err != nil {
+		logrus.WithError(err).Errorf("loading session %s", key)
+	}
+	if !found || values == nil {
+		values = map[string]string{}
+	}
+	state.sessionValue = &Session{key: key, values: values, closed: state.closed, store: u.sessionStore, ttl: u.sessionTTL}
+	return state.sessionValue
+}
+
+// close saves session if it was used, later changes are saved as they are made
+func (u *updateScope) close() {
+	state := u.state
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	state.closed = true
+	s := state.sessionValue
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.saveLocked()
+	s.closed = true
+}
+
+// NewMemorySessionStore makes SessionStore keeping sessions in memory only
+func NewMemorySessionStore() SessionStore {
+	return &memorySessionStore{
+		sessions: map[string]storedSession{},
+	}
+}
+
+type storedSession struct {
+	Values    map[string]string `json:"values"`
+	ExpiresAt time.Time         `json:"expires_at,omitempty"`
+}
+
+func (s storedSession) expired() bool {
+	return !s.ExpiresAt.IsZero() && time.Now().After(s.ExpiresAt)
+}
+
+func newStoredSession(values map[string]string, ttl time.Duration) storedSession {
+	stored := storedSession{Values: map[string]string{}}
+	for key, value := range values {
+		stored.Values[key] = value
+	}
+	if ttl > 0 {
+		stored.ExpiresAt = time.Now().Add(ttl)
+	}
+	return stored
+}
+
+type memorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]storedSession
+}
+
+func (s *memorySessionStore) Load(key string) (map[string]string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, found := s.sessions[key]
+	if !found {
+		return nil, false, nil
+	}
+	if stored.expired() {
+		delete(s.sessions, key)
+		return nil, false, nil
+	}
+	return newStoredSession(stored.Values, 0).Values, true, nil
+}
+
+func (s *memorySessionStore) Save(key string, values map[string]string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, stored := range s.sessions {
+		if stored.expired() {
+			delete(s.sessions, k)
+		}
+	}
+	s.sessions[key] = newStoredSession(values, ttl)
+	return nil
+}
+
+func (s *memorySessionStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, key)
+	return nil
+}
+
+// FileSessionStore keeps every session in its own JSON file in directory, files are replaced atomically
+type FileSessionStore struct {
+	dir string
+}
+
+// NewFileSessionStore keeps sessions in dir, it is created on first save
+func NewFileSessionStore(dir string) *FileSessionStore {
+	return &FileSessionStore{dir: dir}
+}
+
+func (s *FileSessionStore) filename(key string) string {
+	return filepath.Join(s.dir, url.PathEscape(key)+".json")
+}
+
+func (s *FileSessionStore) Load(key string) (map[string]string, bool, error) {
+	data, err := ioutil.ReadFile(s.filename(key))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	stored := storedSession{}
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, false, err
+	}
+	if stored.expired() {
+		return nil, false, s.Delete(key)
+	}
+	return stored.Values, true, nil
+}
+
+func (s *FileSessionStore) Save(key string, values map[string]string, ttl time.Duration) error {
+	data, err := json.Marshal(newStoredSession(values, ttl))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(s.filename(key), data)
+}
+
+func (s *FileSessionStore) Delete(key string) error {
+	err := os.Remove(s.filename(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// PurgeExpired removes files of expired sessions, they are otherwise removed only when loaded
+func (s *FileSessionStore) PurgeExpired() error {
+	files, err := ioutil.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+		key, err := url.PathUnescape(file.Name()[:len(file.Name())-len(".json")])
+		if err != nil {
+			continue
+		}
+		if _, _, err := s.Load(key); err != nil {
+			logrus.WithError(err).Warnf("checking session %s", key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package bot
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/alexcom/tba/telegram"
+)
+
+func TestSessionStoresExpireSessions(t *testing.T) {
+	dir, removeDir := tempDir(t)
+	defer removeDir()
+	stores := map[string]SessionStore{
+		"memory": NewMemorySessionStore(),
+		"file":   NewFileSessionStore(dir),
+	}
+	for name, store := range stores {
+		if err := store.Save("short", map[string]string{"k": "v"}, 20*time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Save("forever", map[string]string{"k": "v"}, 0); err != nil {
+			t.Fatal(err)
+		}
+		if values, found, err := store.Load("short"); !found || err != nil || values["k"] != "v" {
+			t.Errorf("%s: fresh session = %v, %v, %v", name, values, found, err)
+		}
+		time.Sleep(40 * time.Millisecond)
+		if values, found, err := store.Load("short"); found || err != nil {
+			t.Errorf("%s: expired session = %v, %v, %v", name, values, found, err)
+		}
+		if _, found, err := store.Load("forever"); !found || err != nil {
+			t.Errorf("%s: session without TTL expired, err = %v", name, err)
+		}
+	}
+}
+
+func TestFileSessionStorePurgesExpiredFiles(t *testing.T) {
+	dir, removeDir := tempDir(t)
+	defer removeDir()
+	store := NewFileSessionStore(dir)
+	_ = store.Save("chat/1/user/1", map[string]string{"k": "v"}, 10*time.Millisecond)
+	_ = store.Save("chat/2/user/2", map[string]string{"k": "v"}, time.Hour)
+	time.Sleep(30 * time.Millisecond)
+	if err := store.PurgeExpired(); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 || files[0].Name() != "chat%2F2%2Fuser%2F2.json" {
+		var names []string
+		for _, file := range files {
+			names = append(names, file.Name())
+		}
+		t.Errorf("session files after purge %v, want only unexpired one", names)
+	}
+}
+
+func TestSessionScopes(t *testing.T) {
+	type origin struct{ chatID, userID int }
+	tests := []struct {
+		scope ConversationScope
+		// visible tells who sees value set by user 1 in chat 10
+		visible map[origin]bool
+	}{
+		{ScopeChatUser, map[origin]bool{{10, 1}: true, {10, 2}: false, {11, 1}: false}},
+		{ScopeChat, map[origin]bool{{10, 1}: true, {10, 2}: true, {11, 1}: false}},
+		{ScopeUser, map[origin]bool{{10, 1}: true, {10, 2}: false, {11, 1}: true}},
+	}
+	for _, test := range tests {
+		bot, closeAPI := newFakeAPIBot(t, newFakeAPI(nil), Options{SessionScope: test.scope})
+		var got string
+		bot.HandleFunc(func(c *Context) (bool, error) {
+			if c.Message.Text == "set" {
+				c.Session().Set("secret", "42")
+			} else {
+				got = c.Session().Get("secret")
+			}
+			return false, nil
+		})
+		update := func(o origin, text string) *telegram.Update {
+			return &telegram.Update{Message: &telegram.Message{
+				Chat: &telegram.Chat{ID: o.chatID},
+				From: &telegram.User{ID: o.userID},
+				Text: text,
+			}}
+		}
+		bot.processUpdate(context.Background(), update(origin{10, 1}, "set"))
+		for o, visible := range test.visible {
+			got = ""
+			bot.processUpdate(context.Background(), update(o, "get"))
+			if (got == "42") != visible {
+				t.Errorf("scope %d: user %d in chat %d sees %q, visible = %v", test.scope, o.userID, o.chatID, got, visible)
+			}
+		}
+		closeAPI()
+	}
+}
@@ -5,12 +5,21 @@ import (
 	"github.com/sirupsen/logrus"
 	"os"
 	"path/filepath"
+	"strconv"
+	"sync"
 )
 
+// StatusStore keeps ID of the last handled update between restarts
+type StatusStore interface {
+	// Load returns zero if nothing was saved yet
+	Load() (lastUpdate int, err error)
+	Save(lastUpdate int) error
+}
+
 type Status struct {
 	lastUpdate int
 	changed    bool
-	filename   string
+	store      StatusStore
 }
 
 type jsonStatus struct {
@@ -19,29 +28,19 @@ type jsonStatus struct {
 
 const statusFileName = "status.json"
 
+// LoadStatus loads status from status.json in workingDir
 func LoadStatus(workingDir string) (*Status, error) {
-	filename := filepath.Join(workingDir, statusFileName)
-	logrus.Infof("loading status from file: %s", filename)
-	file, err := os.Open(filename)
-	if err != nil {
-		if os.IsNotExist(err) {
-			logrus.Info("no status file, will create new one:", filename)
-			return &Status{
-				filename: filename,
-			}, nil
-		}
-		return nil, err
-	}
-	decoder := json.NewDecoder(file)
-	jStatus := jsonStatus{}
-	err = decoder.Decode(&jStatus)
+	return LoadStatusFrom(NewFileStatusStore(filepath.Join(workingDir, statusFileName)))
+}
+
+func LoadStatusFrom(store StatusStore) (*Status, error) {
+	lastUpdate, err := store.Load()
 	if err != nil {
 		return nil, err
 	}
-	logrus.Info("status reading success")
 	return &Status{
-		lastUpdate: jStatus.LastUpdate,
-		filename:   filename,
+		lastUpdate: lastUpdate,
+		store:      store,
 	}, nil
 }
 
@@ -69,20 +68,97 @@ func (s *Status) Save() error {
 	if !s.changed {
 		return nil
 	}
-	file, err := os.OpenFile(s.filename, os.O_RDWR|os.O_CREATE, 0644)
-	if err != nil {
+	if err := s.store.Save(s.lastUpdate); err != nil {
 		return err
 	}
-	encoder := json.NewEncoder(file)
-	err = encoder.Encode(&jsonStatus{LastUpdate: s.lastUpdate})
+	s.changed = false
+	return nil
+}
+
+// FileStatusStore keeps status in JSON file replaced atomically on every save,
+// so crash leaves either previous or new status but never a partial one
+type FileStatusStore struct {
+	filename string
+}
+
+func NewFileStatusStore(filename string) *FileStatusStore {
+	return &FileStatusStore{filename: filename}
+}
+
+func (s *FileStatusStore) Load() (int, error) {
+	logrus.Infof("loading status from file: %s", s.filename)
+	file, err := os.Open(s.filename)
 	if err != nil {
-		return err
+		if os.IsNotExist(err) {
+			logrus.Info("no status file, will create new one:", s.filename)
+			return 0, nil
+		}
+		return 0, err
 	}
-	err = file.Close()
+	defer closeOrWarn(file)
+	jStatus := jsonStatus{}
+	if err := json.NewDecoder(file).Decode(&jStatus); err != nil {
+		return 0, err
+	}
+	logrus.Info("status reading success")
+	return jStatus.LastUpdate, nil
+}
+
+func (s *FileStatusStore) Save(lastUpdate int) error {
+	data, err := json.Marshal(&jsonStatus{LastUpdate: lastUpdate})
 	if err != nil {
 		return err
 	}
-	s.changed = false
-	logrus.Info("status saving success")
+	if err := writeFileAtomic(s.filename, append(data, '\n')); err != nil {
+		return err
+	}
+	logrus.Debug("status saving success")
 	return nil
 }
+
+// MemoryStatusStore keeps status in memory only, useful for tests
+type MemoryStatusStore struct {
+	mu         sync.Mutex
+	lastUpdate int
+}
+
+func NewMemoryStatusStore() *MemoryStatusStore {
+	return &MemoryStatusStore{}
+}
+
+func (s *MemoryStatusStore) Load() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastUpdate, nil
+}
+
+func (s *MemoryStatusStore) Save(lastUpdate int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastUpdate = lastUpdate
+	return nil
+}
+
+// KVStatusStore keeps status under a key of KVStore
+type KVStatusStore struct {
+	kv  KVStore
+	key string
+}
+
+const statusKey = "status/last_update"
+
+func NewKVStatusStore(kv KVStore) *KVStatusStore {
+	return &KVStatusStore{kv: kv, key: statusKey}
+}
+
+func (s *KVStatusStore) Load() (int, error) {
+	value, found, err := s.kv.Get(s.key)
+	if err != nil || !found {
+		return 0, err
+	}
+	return strconv.Atoi(string(value))
+}
+
+func (s *KVStatusStore) Save(lastUpdate int) error {
+	return s.kv.Put(s.key, []byte(strconv.Itoa(lastUpdate)))
+}
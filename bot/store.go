@@ -0,0 +1,350 @@
+package bot
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// KVStore is key-value storage for bot state which has to survive restarts
+type KVStore interface {
+	// Get returns value stored under key, found is false if there is none
+	Get(key string) (value []byte, found bool, err error)
+	Put(key string, value []byte) error
+	Delete(key string) error
+	// Keys lists keys starting with prefix in ascending order
+	Keys(prefix string) ([]string, error)
+}
+
+// storeFileName is name of FileKVStore used under Options.WorkingDir when no Options.Store is given
+const storeFileName = "store.log"
+
+// lazyFileKVStore is FileKVStore opened on first use, so default store file is not created
+// or held open by bot which never needs it. Failed open is retried on next use.
+type lazyFileKVStore struct {
+	path  string
+	mu    sync.Mutex
+	store *FileKVStore
+}
+
+func newLazyFileKVStore(path string) *lazyFileKVStore {
+	return &lazyFileKVStore{path: path}
+}
+
+func (s *lazyFileKVStore) open() (*FileKVStore, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.store != nil {
+		return s.store, nil
+	}
+	store, err := OpenFileKVStore(s.path)
+	if err != nil {
+		return nil, err
+	}
+	s.store = store
+	return store, nil
+}
+
+func (s *lazyFileKVStore) Get(key string) ([]byte, bool, error) {
+	store, err := s.open()
+	if err != nil {
+		return nil, false, err
+	}
+	return store.Get(key)
+}
+
+func (s *lazyFileKVStore) Put(key string, value []byte) error {
+	store, err := s.open()
+	if err != nil {
+		return err
+	}
+	return store.Put(key, value)
+}
+
+func (s *lazyFileKVStore) Delete(key string) error {
+	store, err := s.open()
+	if err != nil {
+		return err
+	}
+	return store.Delete(key)
+}
+
+func (s *lazyFileKVStore) Keys(prefix string) ([]string, error) {
+	store, err := s.open()
+	if err != nil {
+		return nil, err
+	}
+	return store.Keys(prefix)
+}
+
+// Close closes file if store was opened, store is opened again on next use
+func (s *lazyFileKVStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.store == nil {
+		return nil
+	}
+	err := s.store.Close()
+	s.store = nil
+	return err
+}
+
+// NewMemoryKVStore makes KVStore keeping everything in memory, useful for tests
+func NewMemoryKVStore() KVStore {
+	return &memoryKVStore{
+		values: map[string][]byte{},
+	}
+}
+
+type memoryKVStore struct {
+	mu     sync.RWMutex
+	values map[string][]byte
+}
+
+func (s *memoryKVStore) Get(key string) ([]byte, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, found := s.values[key]
+	return value, found, nil
+}
+
+func (s *memoryKVStore) Put(key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = append([]byte(nil), value...)
+	return nil
+}
+
+func (s *memoryKVStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, key)
+	return nil
+}
+
+func (s *memoryKVStore) Keys(prefix string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return sortedKeys(s.values, prefix), nil
+}
+
+func sortedKeys(values map[string][]byte, prefix string) []string {
+	keys := make([]string, 0)
+	for key := range values {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// FileKVStore is embedded KVStore persisting every change to append only log file.
+// Log is replayed on open and compacted when it gets much bigger than live data.
+// Half written record left by a crash is dropped on open.
+type FileKVStore struct {
+	mu      sync.RWMutex
+	path    string
+	file    *os.File
+	values  map[string][]byte
+	records int
+}
+
+type kvRecord struct {
+	Key     string `json:"k"`
+	Value   []byte `json:"v,omitempty"`
+	Deleted bool   `json:"d,omitempty"`
+}
+
+const minCompactionRecords = 1000
+
+// OpenFileKVStore opens store kept in file at path, creating it if needed
+func OpenFileKVStore(path string) (*FileKVStore, error) {
+	s := &FileKVStore{
+		path:   path,
+		values: map[string][]byte{},
+	}
+	if err := s.replay(); err != nil {
+		return nil, err
+	}
+	// rewriting log on open also drops a record broken by crash
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileKVStore) replay() error {
+	file, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer closeOrWarn(file)
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(bytes.TrimSpace(line)) != 0 {
+				logrus.Warnf("dropping incomplete record at the end of %s", s.path)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var record kvRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return err
+		}
+		s.apply(record)
+		s.records++
+	}
+}
+
+func (s *FileKVStore) apply(record kvRecord) {
+	if record.Deleted {
+		delete(s.values, record.Key)
+	} else {
+		s.values[record.Key] = record.Value
+	}
+}
+
+// compact writes live values to new log and atomically replaces old one with it
+func (s *FileKVStore) compact() error {
+	if s.file != nil {
+		closeOrWarn(s.file)
+		s.file = nil
+	}
+	buffer := bytes.Buffer{}
+	encoder := json.NewEncoder(&buffer)
+	for _, key := range sortedKeys(s.values, "") {
+		if err := encoder.Encode(kvRecord{Key: key, Value: s.values[key]}); err != nil {
+			return err
+		}
+	}
+	if err := writeFileAtomic(s.path, buffer.Bytes()); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	s.file = file
+	s.records = len(s.values)
+	return nil
+}
+
+func (s *FileKVStore) append(record kvRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	s.apply(record)
+	s.records++
+	if s.records > minCompactionRecords && s.records > 2*len(s.values) {
+		return s.compact()
+	}
+	return nil
+}
+
+func (s *FileKVStore) Get(key string) ([]byte, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, found := s.values[key]
+	return value, found, nil
+}
+
+func (s *FileKVStore) Put(key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.append(kvRecord{Key: key, Value: append([]byte(nil), value...)})
+}
+
+func (s *FileKVStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, found := s.values[key]; !found {
+		return nil
+	}
+	return s.append(kvRecord{Key: key, Deleted: true})
+}
+
+func (s *FileKVStore) Keys(prefix string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return sortedKeys(s.values, prefix), nil
+}
+
+// Close closes log file, store must not be used after that
+func (s *FileKVStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// writeFileAtomic replaces file at path with data, so readers see either old or new content even after crash
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpName, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmpName, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmpName)
+		return err
+	}
+	syncDir(dir)
+	return nil
+}
+
+// syncDir makes rename durable, not every platform supports syncing directories so errors are ignored
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	_ = d.Sync()
+	_ = d.Close()
+}
+
+func closeOrWarn(closer io.Closer) {
+	if err := closer.Close(); err != nil {
+		logrus.WithError(err).Warn("closing resource")
+	}
+}
@@ -0,0 +1,170 @@
+package bot
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "bot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { _ = os.RemoveAll(dir) }
+}
+
+func openStore(t *testing.T, path string) *FileKVStore {
+	store, err := OpenFileKVStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func assertValue(t *testing.T, store KVStore, key, want string, wantFound bool) {
+	t.Helper()
+	value, found, err := store.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != wantFound || string(value) != want {
+		t.Fatalf("%s = %q, %v, want %q, %v", key, value, found, want, wantFound)
+	}
+}
+
+func TestFileKVStoreReopenRestoresState(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "store.log")
+	store := openStore(t, path)
+	for _, key := range []string{"a", "b", "c"} {
+		if err := store.Put(key, []byte("value "+key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = store.Put("b", []byte("changed"))
+	_ = store.Delete("c")
+	_ = store.Close()
+
+	store = openStore(t, path)
+	defer store.Close()
+	assertValue(t, store, "a", "value a", true)
+	assertValue(t, store, "b", "changed", true)
+	// deletion survives reopen even though the put before it is in the log too
+	assertValue(t, store, "c", "", false)
+	if keys, _ := store.Keys(""); len(keys) != 2 {
+		t.Fatalf("keys = %v, want a and b", keys)
+	}
+}
+
+func TestFileKVStoreDropsTruncatedRecord(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "store.log")
+	store := openStore(t, path)
+	_ = store.Put("kept", []byte("1"))
+	_ = store.Put("torn", []byte("2"))
+	_ = store.Close()
+	data, _ := ioutil.ReadFile(path)
+	// crash in the middle of the last write leaves it without its end and newline
+	if err := ioutil.WriteFile(path, data[:len(data)-5], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	store = openStore(t, path)
+	assertValue(t, store, "kept", "1", true)
+	assertValue(t, store, "torn", "", false)
+	if err := store.Put("after", []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+	_ = store.Close()
+
+	store = openStore(t, path)
+	defer store.Close()
+	assertValue(t, store, "kept", "1", true)
+	assertValue(t, store, "after", "3", true)
+}
+
+func TestFileKVStoreCompactionKeepsLiveKeysOnly(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "store.log")
+	store := openStore(t, path)
+	defer store.Close()
+	_ = store.Put("live", []byte("x"))
+	for i := 0; i <= minCompactionRecords; i++ {
+		key := "temp" + strconv.Itoa(i%10)
+		if err := store.Put(key, []byte(strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+		if i%10 == 9 {
+			for j := 0; j < 10; j++ {
+				_ = store.Delete("temp" + strconv.Itoa(j))
+			}
+		}
+	}
+	data, _ := ioutil.ReadFile(path)
+	if lines := bytes.Count(data, []byte("\n")); lines > minCompactionRecords {
+		t.Fatalf("log has %d records, it was not compacted", lines)
+	}
+	_ = store.Close()
+	store = openStore(t, path)
+	keys, _ := store.Keys("")
+	if len(keys) != 2 || keys[0] != "live" || keys[1] != "temp0" {
+		t.Fatalf("keys after compaction = %v, want live and temp0", keys)
+	}
+	assertValue(t, store, "live", "x", true)
+}
+
+func TestWriteFileAtomicReplacesFile(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "status.json")
+	for _, content := range []string{"first", "second"} {
+		if err := writeFileAtomic(path, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+		if data, _ := ioutil.ReadFile(path); string(data) != content {
+			t.Fatalf("file has %q, want %q", data, content)
+		}
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Fatalf("%d files left in directory, want only the target", len(files))
+	}
+}
+
+func TestFileStatusStoreRoundTrip(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	store := NewFileStatusStore(filepath.Join(dir, statusFileName))
+	if lastUpdate, err := store.Load(); err != nil || lastUpdate != 0 {
+		t.Fatalf("missing status loaded as %d, %v, want 0", lastUpdate, err)
+	}
+	status, err := LoadStatusFrom(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status.SetUpdate(42)
+	status.SetUpdate(41)
+	if err := status.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if lastUpdate, err := store.Load(); err != nil || lastUpdate != 42 {
+		t.Fatalf("status loaded as %d, %v, want 42", lastUpdate, err)
+	}
+}
+
+func TestKVStatusStoreRoundTrip(t *testing.T) {
+	store := NewKVStatusStore(NewMemoryKVStore())
+	if err := store.Save(7); err != nil {
+		t.Fatal(err)
+	}
+	if lastUpdate, err := store.Load(); err != nil || lastUpdate != 7 {
+		t.Fatalf("status loaded as %d, %v, want 7", lastUpdate, err)
+	}
+}
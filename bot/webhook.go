@@ -0,0 +1,151 @@
+package bot
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"github.com/alexcom/tba/telegram"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// SecretTokenHeader carries secret token given to setWebhook in every webhook request
+const SecretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+const webhookShutdownTimeout = 10 * time.Second
+
+type WebhookOptions struct {
+	// URL is public HTTPS address Telegram sends updates to, its path is served by the handler
+	URL string
+	// ListenAddr is address of local HTTP server, e.g. ":8443"
+	ListenAddr string
+	// SecretToken protects webhook from requests not coming from Telegram
+	SecretToken string
+	// CertFile and KeyFile make server serve HTTPS itself instead of relying on reverse proxy
+	CertFile string
+	KeyFile  string
+	// Certificate is public key of self-signed certificate to upload to Telegram
+	Certificate        *telegram.InputFile
+	IPAddress          string
+	MaxConnections     int
+	DropPendingUpdates bool
+	// KeepWebhook leaves webhook registered on shutdown, e.g. when other instance takes over
+	KeepWebhook bool
+}
+
+type webhookHandler struct {
+	bot         *Bot
+	secretToken string
+}
+
+// maxWebhookBodySize is far above any update Telegram sends, larger requests are rejected unread
+const maxWebhookBodySize = 1 << 20
+
+// WebhookHandler makes http.Handler accepting updates sent by Telegram to webhook.
+// Requests without matching X-Telegram-Bot-Api-Secret-Token header are rejected unless secretToken is empty.
+func (bot *Bot) WebhookHandler(secretToken string) http.Handler {
+	return &webhookHandler{
+		bot:         bot,
+		secretToken: secretToken,
+	}
+}
+
+func (h *webhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.secretToken != "" {
+		token := r.Header.Get(SecretTokenHeader)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(h.secretToken)) != 1 {
+			logrus.Warnf("webhook request from %s with wrong secret token", r.RemoteAddr)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+	}
+	var update telegram.Update
+	body := http.MaxBytesReader(w, r.Body, maxWebhookBodySize)
+	if err := json.NewDecoder(body).Decode(&update); err != nil {
+		logrus.WithError(err).Error("decoding webhook update")
+		// MaxBytesReader error has no type to check before Go 1.19
+		if strings.Contains(err.Error(), "request body too large") {
+			http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	h.bot.handleUpdate(r.Context(), &update)
+	w.WriteHeader(http.StatusOK)
+}
+
+// RunWebhook registers webhook, serves updates until ctx is done, then stops server and deletes webhook.
+// Returns ctx.Err() after shutdown or error which stopped the server.
+func (bot *Bot) RunWebhook(ctx context.Context, opts WebhookOptions) error {
+	webhookURL, err := url.Parse(opts.URL)
+	if err != nil {
+		return err
+	}
+	path := webhookURL.Path
+	if path == "" {
+		path = "/"
+	}
+	bot.syncCommandsOnStart(ctx)
+	mux := http.NewServeMux()
+	mux.Handle(path, bot.WebhookHandler(opts.SecretToken))
+	server := &http.Server{
+		Addr:    opts.ListenAddr,
+		Handler: mux,
+	}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		if opts.CertFile != "" {
+			serverErr <- server.ListenAndServeTLS(opts.CertFile, opts.KeyFile)
+		} else {
+			serverErr <- server.ListenAndServe()
+		}
+	}()
+
+	_, err = bot.Telegram.SetWebhookContext(ctx, telegram.SetWebhookRequest{
+		URL:                opts.URL,
+		Certificate:        opts.Certificate,
+		IPAddress:          opts.IPAddress,
+		MaxConnections:     opts.MaxConnections,
+		AllowedUpdates:     bot.AllowedUpdates(),
+		DropPendingUpdates: opts.DropPendingUpdates,
+		SecretToken:        opts.SecretToken,
+	})
+	if err != nil {
+		bot.shutdownWebhook(server, false)
+		return err
+	}
+	logrus.Infof("webhook registered, listening on %s", opts.ListenAddr)
+
+	select {
+	case <-ctx.Done():
+		bot.shutdownWebhook(server, !opts.KeepWebhook)
+		return ctx.Err()
+	case err = <-serverErr:
+		bot.shutdownWebhook(server, !opts.KeepWebhook)
+		return err
+	}
+}
+
+func (bot *Bot) shutdownWebhook(server *http.Server, deleteWebhook bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		logrus.WithError(err).Warn("webhook server shutdown")
+	}
+	if !deleteWebhook {
+		return
+	}
+	if _, err := bot.Telegram.DeleteWebhookContext(ctx, telegram.DeleteWebhookRequest{}); err != nil {
+		logrus.WithError(err).Error("deleting webhook")
+	}
+}
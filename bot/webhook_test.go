@@ -0,0 +1,66 @@
+package bot
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/alexcom/tba/telegram"
+)
+
+func TestWebhookHandler(t *testing.T) {
+	fake := newFakeAPI(nil)
+	bot, closeAPI := newFakeAPIBot(t, fake, Options{})
+	defer closeAPI()
+	var mu sync.Mutex
+	dispatched := map[int]int{}
+	bot.OnUpdate(func(_ MessageServices, update *telegram.Update) (bool, error) {
+		mu.Lock()
+		dispatched[update.UpdateID]++
+		mu.Unlock()
+		return false, nil
+	})
+	handler := bot.WebhookHandler("s3cret")
+	valid := `{"update_id":7,"message":{"message_id":1,"date":0,"chat":{"id":1,"type":"private"},"text":"hi"}}`
+	tests := []struct {
+		name   string
+		method string
+		token  string
+		body   string
+		status int
+	}{
+		{"valid update", http.MethodPost, "s3cret", valid, http.StatusOK},
+		{"missing token", http.MethodPost, "", valid, http.StatusForbidden},
+		{"wrong token", http.MethodPost, "s3cre", valid, http.StatusForbidden},
+		{"GET", http.MethodGet, "s3cret", "", http.StatusMethodNotAllowed},
+		{"malformed JSON", http.MethodPost, "s3cret", `{"update_id":`, http.StatusBadRequest},
+		{"oversized body", http.MethodPost, "s3cret", `{"update_id":8,"message":{"text":"` + strings.Repeat("x", maxWebhookBodySize) + `"}}`, http.StatusRequestEntityTooLarge},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest(test.method, "/hook", strings.NewReader(test.body))
+		if test.token != "" {
+			request.Header.Set(SecretTokenHeader, test.token)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		if recorder.Code != test.status {
+			t.Errorf("%s: status %d, want %d", test.name, recorder.Code, test.status)
+		}
+	}
+	if len(dispatched) != 1 || dispatched[7] != 1 {
+		t.Fatalf("dispatched updates %v, want update 7 exactly once", dispatched)
+	}
+}
+
+func TestWebhookHandlerWithoutTokenAcceptsAnyRequest(t *testing.T) {
+	fake := newFakeAPI(nil)
+	bot, closeAPI := newFakeAPIBot(t, fake, Options{})
+	defer closeAPI()
+	recorder := httptest.NewRecorder()
+	bot.WebhookHandler("").ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"update_id":1}`)))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", recorder.Code)
+	}
+}
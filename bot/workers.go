@@ -0,0 +1,315 @@
+package bot
+
+import (
+	"context"
+	"github.com/alexcom/tba/telegram"
+	"github.com/sirupsen/logrus"
+	"hash/fnv"
+	"strconv"
+	"sync"
+)
+
+const defaultQueueSize = 100
+
+// workerPool handles updates concurrently keeping updates of the same chat or user in order: update waits
+// while an earlier update with the same ordering key is handled, updates of other chats are handled meanwhile,
+// so one slow chat does not hold back the others. Number of waiting updates is bounded, polling waits when it is reached.
+type workerPool struct {
+	mu   sync.Mutex
+	wake *sync.Cond
+	// keys holds waiting updates by ordering key, key stays while its update is handled
+	keys map[uint32][]*telegram.Update
+	// ready are keys with waiting updates and none handled, in order they became ready
+	ready   []uint32
+	closed  bool
+	slots   chan struct{}
+	wg      sync.WaitGroup
+	tracker *offsetTracker
+}
+
+func (bot Bot) startWorkers(ctx context.Context) *workerPool {
+	workers := bot.workers
+	if workers < 1 {
+		workers = 1
+	}
+	queueSize := bot.queueSize
+	if queueSize < 1 {
+		queueSize = defaultQueueSize
+	}
+	var journal *updateJournal
+	if bot.delivery != DeliveryAtMostOnce {
+		journal = bot.journal
+	}
+	pool := &workerPool{
+		keys:    map[uint32][]*telegram.Update{},
+		slots:   make(chan struct{}, workers*queueSize),
+		tracker: newOffsetTracker(bot.status, bot.delivery, journal),
+	}
+	pool.wake = sync.NewCond(&pool.mu)
+	for i := 0; i < workers; i++ {
+		pool.wg.Add(1)
+		go func() {
+			defer pool.wg.Done()
+			for {
+				key, update, ok := pool.next()
+				if !ok {
+					return
+				}
+				// updates left on shutdown are not committed, they stay in journal and are replayed on restart
+				if ctx.Err() == nil {
+					bot.handleUpdate(ctx, update)
+					pool.tracker.completed(update.UpdateID)
+				}
+				pool.finish(key)
+			}
+		}()
+	}
+	return pool
+}
+
+// submit queues update behind earlier updates with the same ordering key, blocks while too many updates wait.
+// Update must be passed to tracker.dispatched before.
+func (p *workerPool) submit(ctx context.Context, update *telegram.Update) error {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key := orderingKey(update)
+	queue, busy := p.keys[key]
+	p.keys[key] = append(queue, update)
+	if !busy {
+		p.ready = append(p.ready, key)
+		p.wake.Signal()
+	}
+	return nil
+}
+
+// next waits for update whose key has no update in progress, ok is false once pool is stopped and drained
+func (p *workerPool) next() (key uint32, update *telegram.Update, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for len(p.ready) == 0 && !p.closed {
+		p.wake.Wait()
+	}
+	if len(p.ready) == 0 {
+		return 0, nil, false
+	}
+	key = p.ready[0]
+	p.ready = p.ready[1:]
+	update = p.keys[key][0]
+	p.keys[key] = p.keys[key][1:]
+	return key, update, true
+}
+
+// finish makes next update of key ready once update of key is done
+func (p *workerPool) finish(key uint32) {
+	p.mu.Lock()
+	if len(p.keys[key]) == 0 {
+		delete(p.keys, key)
+	} else {
+		p.ready = append(p.ready, key)
+		p.wake.Signal()
+	}
+	p.mu.Unlock()
+	<-p.slots
+}
+
+// stop waits for handlers in progress to finish
+func (p *workerPool) stop() {
+	p.mu.Lock()
+	p.closed = true
+	p.wake.Broadcast()
+	p.mu.Unlock()
+	p.wg.Wait()
+}
+
+// keyLocks serializes handling of updates with the same key which workers may otherwise handle concurrently,
+// e.g. updates of one user from different chats. Locks are dropped when nobody holds or waits for them.
+type keyLocks struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+	refs int
+}
+
+func newKeyLocks() *keyLocks {
+	return &keyLocks{locks: map[string]*keyLock{}}
+}
+
+func (l *keyLocks) lock(key string) (unlock func()) {
+	l.mu.Lock()
+	lock := l.locks[key]
+	if lock == nil {
+		lock = &keyLock{}
+		l.locks[key] = lock
+	}
+	lock.refs++
+	l.mu.Unlock()
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(l.locks, key)
+		}
+	}
+}
+
+// orderingKey is the same for updates which must be handled in order they were received
+func orderingKey(update *telegram.Update) uint32 {
+	var key string
+	switch {
+	case update.Message != nil && update.Message.Chat != nil:
+		key = "chat" + strconv.Itoa(update.Message.Chat.ID)
+	case update.EditedMessage != nil && update.EditedMessage.Chat != nil:
+		key = "chat" + strconv.Itoa(update.EditedMessage.Chat.ID)
+	case update.ChannelPost != nil && update.ChannelPost.Chat != nil:
+		key = "chat" + strconv.Itoa(update.ChannelPost.Chat.ID)
+	case update.EditedChannelPost != nil && update.EditedChannelPost.Chat != nil:
+		key = "chat" + strconv.Itoa(update.EditedChannelPost.Chat.ID)
+	case update.CallbackQuery != nil && update.CallbackQuery.Message != nil && update.CallbackQuery.Message.Chat != nil:
+		key = "chat" + strconv.Itoa(update.CallbackQuery.Message.Chat.ID)
+	case update.CallbackQuery != nil && update.CallbackQuery.From != nil:
+		key = "user" + strconv.Itoa(update.CallbackQuery.From.ID)
+	case update.InlineQuery != nil && update.InlineQuery.From != nil:
+		key = "user" + strconv.Itoa(update.InlineQuery.From.ID)
+	case update.ChosenInlineResult != nil && update.ChosenInlineResult.From != nil:
+		key = "user" + strconv.Itoa(update.ChosenInlineResult.From.ID)
+	case update.ShippingQuery != nil && update.ShippingQuery.From != nil:
+		key = "user" + strconv.Itoa(update.ShippingQuery.From.ID)
+	case update.PreCheckoutQuery != nil && update.PreCheckoutQuery.From != nil:
+		key = "user" + strconv.Itoa(update.PreCheckoutQuery.From.ID)
+	case update.Poll != nil:
+		key = "poll" + update.Poll.ID
+	default:
+		key = "update" + strconv.Itoa(update.UpdateID)
+	}
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(key))
+	return hash.Sum32()
+}
+
+// offsetTracker advances status only past updates which were handled together with all updates before them,
+// so no update is skipped after restart even though workers finish them out of order.
+// Updates are journaled when dispatched and removed from journal once handled, so polling goes on
+// past updates in progress and those are replayed from journal after restart.
+// With DeliveryAtMostOnce status advances as soon as update is dispatched and nothing is journaled.
+type offsetTracker struct {
+	mu       sync.Mutex
+	status   *Status
+	delivery DeliveryMode
+	journal  *updateJournal
+	pending  []int
+	done     map[int]bool
+	// lastDispatched is the highest update ID passed to workers, polling continues right after it
+	lastDispatched int
+	// handled are updates still in journal, DeliveryBatch removes them when status is saved
+	handled []int
+}
+
+func newOffsetTracker(status *Status, delivery DeliveryMode, journal *updateJournal) *offsetTracker {
+	return &offsetTracker{
+		status:         status,
+		delivery:       delivery,
+		journal:        journal,
+		done:           map[int]bool{},
+		lastDispatched: status.LastUpdate(),
+	}
+}
+
+// committed returns ID of the last committed update
+func (t *offsetTracker) committed() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.status.LastUpdate()
+}
+
+// fetched returns ID of the last dispatched update, polling continues right after it
+func (t *offsetTracker) fetched() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.lastDispatched
+}
+
+// fresh drops updates which were dispatched already
+func (t *offsetTracker) fresh(updates []telegram.Update) []*telegram.Update {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var result []*telegram.Update
+	for i := range updates {
+		if updates[i].UpdateID > t.lastDispatched {
+			result = append(result, &updates[i])
+		}
+	}
+	return result
+}
+
+// dispatched journals update, it must be done before polling goes past update and confirms it to Telegram
+func (t *offsetTracker) dispatched(update *telegram.Update) error {
+	if t.journal != nil {
+		if err := t.journal.put(update); err != nil {
+			return err
+		}
+	}
+	t.replayed(update)
+	return nil
+}
+
+// replayed tracks update which is in journal already
+func (t *offsetTracker) replayed(update *telegram.Update) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if update.UpdateID > t.lastDispatched {
+		t.lastDispatched = update.UpdateID
+	}
+	if t.delivery == DeliveryAtMostOnce {
+		t.status.SetUpdate(update.UpdateID)
+		return
+	}
+	t.pending = append(t.pending, update.UpdateID)
+}
+
+func (t *offsetTracker) completed(updateID int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.delivery == DeliveryAtMostOnce {
+		return
+	}
+	t.done[updateID] = true
+	for len(t.pending) > 0 && t.done[t.pending[0]] {
+		delete(t.done, t.pending[0])
+		t.status.SetUpdate(t.pending[0])
+		t.pending = t.pending[1:]
+	}
+	if t.delivery != DeliveryAtLeastOnce {
+		t.handled = append(t.handled, updateID)
+		return
+	}
+	if err := t.journal.delete(updateID); err != nil {
+		logrus.WithError(err).Errorf("removing update %d from journal", updateID)
+	}
+	if err := t.status.Save(); err != nil {
+		logrus.WithError(err).Error("fail saving status")
+	}
+}
+
+// save writes status if it changed and removes handled updates from journal, it must not race with completed
+func (t *offsetTracker) save() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for len(t.handled) > 0 {
+		if err := t.journal.delete(t.handled[0]); err != nil {
+			return err
+		}
+		t.handled = t.handled[1:]
+	}
+	return t.status.Save()
+}
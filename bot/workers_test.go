@@ -0,0 +1,357 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alexcom/tba/telegram"
+)
+
+func newTestTracker(statusStore StatusStore, delivery DeliveryMode) (*offsetTracker, KVStore) {
+	status, _ := LoadStatusFrom(statusStore)
+	kv := NewMemoryKVStore()
+	return newOffsetTracker(status, delivery, newUpdateJournal(kv)), kv
+}
+
+func dispatch(t *testing.T, tracker *offsetTracker, ids ...int) {
+	for _, id := range ids {
+		if err := tracker.dispatched(&telegram.Update{UpdateID: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func journaled(kv KVStore) int {
+	keys, _ := kv.Keys(journalKeyPrefix)
+	return len(keys)
+}
+
+func TestOffsetTrackerCommitsContiguousPrefix(t *testing.T) {
+	tracker, _ := newTestTracker(NewMemoryStatusStore(), DeliveryBatch)
+	dispatch(t, tracker, 1, 2, 3, 4)
+	if got := tracker.fetched(); got != 4 {
+		t.Fatalf("fetched = %d, want 4", got)
+	}
+	tracker.completed(3)
+	tracker.completed(2)
+	if got := tracker.committed(); got != 0 {
+		t.Fatalf("committed = %d before update 1 completed, want 0", got)
+	}
+	tracker.completed(1)
+	if got := tracker.committed(); got != 3 {
+		t.Fatalf("committed = %d, want 3", got)
+	}
+	tracker.completed(4)
+	if got := tracker.committed(); got != 4 {
+		t.Fatalf("committed = %d, want 4", got)
+	}
+}
+
+func TestOffsetTrackerFreshSkipsDispatched(t *testing.T) {
+	tracker, _ := newTestTracker(NewMemoryStatusStore(), DeliveryBatch)
+	dispatch(t, tracker, 1, 2)
+	fresh := tracker.fresh([]telegram.Update{{UpdateID: 1}, {UpdateID: 2}, {UpdateID: 3}})
+	if len(fresh) != 1 || fresh[0].UpdateID != 3 {
+		t.Fatalf("fresh = %v, want only update 3", fresh)
+	}
+}
+
+func TestOffsetTrackerBatchRemovesHandledFromJournalOnSave(t *testing.T) {
+	tracker, kv := newTestTracker(NewMemoryStatusStore(), DeliveryBatch)
+	dispatch(t, tracker, 1, 2)
+	if n := journaled(kv); n != 2 {
+		t.Fatalf("%d updates journaled, want 2", n)
+	}
+	tracker.completed(2)
+	if n := journaled(kv); n != 2 {
+		t.Fatalf("%d updates journaled before save, want 2", n)
+	}
+	if err := tracker.save(); err != nil {
+		t.Fatal(err)
+	}
+	if n := journaled(kv); n != 1 {
+		t.Fatalf("%d updates journaled after save, want 1", n)
+	}
+}
+
+func TestOffsetTrackerAtMostOnceCommitsOnDispatch(t *testing.T) {
+	status, _ := LoadStatusFrom(NewMemoryStatusStore())
+	tracker := newOffsetTracker(status, DeliveryAtMostOnce, nil)
+	dispatch(t, tracker, 1, 2)
+	if got := tracker.committed(); got != 2 {
+		t.Fatalf("committed = %d, want 2", got)
+	}
+	tracker.completed(1)
+	if got := tracker.committed(); got != 2 {
+		t.Fatalf("committed = %d after completion, want 2", got)
+	}
+}
+
+func TestOffsetTrackerAtLeastOnceSavesEveryCompletion(t *testing.T) {
+	store := NewMemoryStatusStore()
+	tracker, kv := newTestTracker(store, DeliveryAtLeastOnce)
+	dispatch(t, tracker, 1, 2)
+	tracker.completed(1)
+	if saved, _ := store.Load(); saved != 1 {
+		t.Fatalf("saved = %d, want 1", saved)
+	}
+	if n := journaled(kv); n != 1 {
+		t.Fatalf("%d updates journaled, want 1", n)
+	}
+}
+
+func TestOrderingKey(t *testing.T) {
+	chatMessage := func(chatID, userID int) *telegram.Update {
+		return &telegram.Update{Message: &telegram.Message{Chat: &telegram.Chat{ID: chatID}, From: &telegram.User{ID: userID}}}
+	}
+	if orderingKey(chatMessage(5, 1)) != orderingKey(chatMessage(5, 2)) {
+		t.Error("messages of one chat got different keys")
+	}
+	edited := &telegram.Update{EditedMessage: &telegram.Message{Chat: &telegram.Chat{ID: 5}}}
+	if orderingKey(chatMessage(5, 1)) != orderingKey(edited) {
+		t.Error("edit got different key than message of the same chat")
+	}
+	callback := &telegram.Update{CallbackQuery: &telegram.CallbackQuery{
+		From:    &telegram.User{ID: 9},
+		Message: &telegram.Message{Chat: &telegram.Chat{ID: 5}},
+	}}
+	if orderingKey(chatMessage(5, 1)) != orderingKey(callback) {
+		t.Error("callback query got different key than its chat")
+	}
+	inline := &telegram.Update{InlineQuery: &telegram.InlineQuery{From: &telegram.User{ID: 5}}}
+	if orderingKey(chatMessage(5, 1)) == orderingKey(inline) {
+		t.Error("user 5 and chat 5 share key")
+	}
+}
+
+// fakePolling serves getUpdates with given updates, at most 100 at once like Telegram, and records requested offsets
+type fakePolling struct {
+	mu      sync.Mutex
+	updates []telegram.Update
+	offsets []int
+}
+
+func (f *fakePolling) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var request telegram.GetUpdatesRequest
+	_ = json.NewDecoder(r.Body).Decode(&request)
+	f.mu.Lock()
+	f.offsets = append(f.offsets, request.Offset)
+	result := []telegram.Update{}
+	for _, update := range f.updates {
+		if update.UpdateID >= request.Offset && len(result) < 100 {
+			result = append(result, update)
+		}
+	}
+	f.mu.Unlock()
+	if len(result) == 0 {
+		time.Sleep(20 * time.Millisecond)
+	}
+	data, _ := json.Marshal(result)
+	_, _ = fmt.Fprintf(w, `{"ok":true,"result":%s}`, data)
+}
+
+func (f *fakePolling) maxOffset() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	max := 0
+	for _, offset := range f.offsets {
+		if offset > max {
+			max = offset
+		}
+	}
+	return max
+}
+
+func newPollingBot(t *testing.T, api *httptest.Server, store KVStore, statusStore StatusStore, delivery DeliveryMode) *Bot {
+	bot, err := NewBot(Options{
+		APIEndpoint:  api.URL,
+		Store:        store,
+		StatusStore:  statusStore,
+		SessionStore: NewMemorySessionStore(),
+		Delivery:     delivery,
+		Workers:      2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bot
+}
+
+func chatUpdate(id, chatID int) telegram.Update {
+	return telegram.Update{UpdateID: id, Message: &telegram.Message{Chat: &telegram.Chat{ID: chatID}}}
+}
+
+func TestPollingJournalsUpdatesInProgress(t *testing.T) {
+	fake := &fakePolling{updates: []telegram.Update{chatUpdate(1, 1)}}
+	api := httptest.NewServer(fake)
+	defer api.Close()
+	store := NewMemoryKVStore()
+	statusStore := NewMemoryStatusStore()
+	bot := newPollingBot(t, api, store, statusStore, DeliveryBatch)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	bot.OnMessage(func(MessageServices, *telegram.Message) (bool, error) {
+		close(started)
+		<-release
+		return false, nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- bot.RunContext(ctx) }()
+
+	<-started
+	if n := journaled(store); n != 1 {
+		t.Fatalf("%d updates journaled while update 1 is handled, want 1", n)
+	}
+	if saved, _ := statusStore.Load(); saved != 0 {
+		t.Fatalf("saved status = %d while update 1 is handled, want 0", saved)
+	}
+	close(release)
+	deadline := time.Now().Add(2 * time.Second)
+	for journaled(store) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("update 1 was not removed from journal after it was handled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	if saved, _ := statusStore.Load(); saved != 1 {
+		t.Fatalf("saved status = %d, want 1", saved)
+	}
+}
+
+func TestPollingShutdownReplaysUnhandledUpdates(t *testing.T) {
+	fake := &fakePolling{updates: []telegram.Update{chatUpdate(1, 1), chatUpdate(2, 1)}}
+	api := httptest.NewServer(fake)
+	defer api.Close()
+	store := NewMemoryKVStore()
+	statusStore := NewMemoryStatusStore()
+	bot := newPollingBot(t, api, store, statusStore, DeliveryBatch)
+	ctx, cancel := context.WithCancel(context.Background())
+	handled := make(chan int, 2)
+	bot.OnMessage(func(_ MessageServices, message *telegram.Message) (bool, error) {
+		// both updates are in the same chat, so update 2 waits while update 1 is handled
+		handled <- 1
+		cancel()
+		return false, nil
+	})
+	_ = bot.RunContext(ctx)
+	if len(handled) != 1 {
+		t.Fatalf("%d updates handled, want 1", len(handled))
+	}
+	if saved, _ := statusStore.Load(); saved != 1 {
+		t.Fatalf("saved status = %d, want 1", saved)
+	}
+
+	// Telegram does not send confirmed update 2 again, it comes from journal
+	fake.mu.Lock()
+	fake.updates = nil
+	fake.mu.Unlock()
+	restarted := newPollingBot(t, api, store, statusStore, DeliveryBatch)
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	replayed := make(chan int, 1)
+	restarted.HandleFunc(func(c *Context) (bool, error) {
+		replayed <- c.Update.UpdateID
+		cancel()
+		return false, nil
+	})
+	_ = restarted.RunContext(ctx)
+	select {
+	case id := <-replayed:
+		if id != 2 {
+			t.Fatalf("replayed update %d, want 2", id)
+		}
+	default:
+		t.Fatal("update 2 was not replayed after restart")
+	}
+	if n := journaled(store); n != 0 {
+		t.Fatalf("%d updates left in journal, want 0", n)
+	}
+}
+
+func TestPollingBlockedChatDoesNotStallOthers(t *testing.T) {
+	const total = 250
+	var updates []telegram.Update
+	for id := 1; id <= total; id++ {
+		// update 1 comes from chat 1 which blocks, the rest come from other chats
+		updates = append(updates, chatUpdate(id, id))
+	}
+	fake := &fakePolling{updates: updates}
+	api := httptest.NewServer(fake)
+	defer api.Close()
+	bot, err := NewBot(Options{
+		APIEndpoint:  api.URL,
+		Store:        NewMemoryKVStore(),
+		StatusStore:  NewMemoryStatusStore(),
+		SessionStore: NewMemorySessionStore(),
+		Workers:      4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := make(chan struct{})
+	var mu sync.Mutex
+	handled := 0
+	bot.OnMessage(func(_ MessageServices, message *telegram.Message) (bool, error) {
+		if message.Chat.ID == 1 {
+			<-release
+			return false, nil
+		}
+		mu.Lock()
+		handled++
+		mu.Unlock()
+		return false, nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- bot.RunContext(ctx) }()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		n := handled
+		mu.Unlock()
+		if n == total-1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d of %d updates of other chats handled while chat 1 is blocked", n, total-1)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(release)
+	cancel()
+	<-done
+}
+
+func TestPollingAtMostOnceCommitsBeforeHandling(t *testing.T) {
+	fake := &fakePolling{updates: []telegram.Update{chatUpdate(1, 1)}}
+	api := httptest.NewServer(fake)
+	defer api.Close()
+	statusStore := NewMemoryStatusStore()
+	store := NewMemoryKVStore()
+	bot := newPollingBot(t, api, store, statusStore, DeliveryAtMostOnce)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	savedBeforeHandling := make(chan int, 1)
+	bot.OnMessage(func(MessageServices, *telegram.Message) (bool, error) {
+		saved, _ := statusStore.Load()
+		savedBeforeHandling <- saved
+		cancel()
+		return false, nil
+	})
+	_ = bot.RunContext(ctx)
+	if saved := <-savedBeforeHandling; saved != 1 {
+		t.Fatalf("status saved before handling = %d, want 1", saved)
+	}
+	if n := journaled(store); n != 0 {
+		t.Fatalf("%d updates journaled, want none", n)
+	}
+}
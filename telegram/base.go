@@ -2,111 +2,181 @@ package telegram
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/sirupsen/logrus"
 	"io"
 	"io/ioutil"
-	"mime/multipart"
 	"net/http"
-	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"text/template"
+	"time"
 )
 
+// DefaultAPIEndpoint is the public Bot API server used when no other endpoint is configured
+const DefaultAPIEndpoint = "https://api.telegram.org"
+
 const templates = `
-{{define "bot_url"}}https://api.telegram.org/bot{{.Token}}/{{.Method}}{{end}}
-{{define "file_dl_url"}}https://api.telegram.org/file/bot{{.Token}}/{{.Path}}{{end}}
+{{define "bot_url"}}{{.Endpoint}}/bot{{.Token}}/{{.Method}}{{end}}
+{{define "file_dl_url"}}{{.Endpoint}}/file/bot{{.Token}}/{{.Path}}{{end}}
 `
 
-func NewClient(apiToken string, longPollingTimeout int) *BaseClient {
-	return &BaseClient{
+// ClientOption customizes BaseClient created by NewClient
+type ClientOption func(*BaseClient)
+
+// WithAPIEndpoint points client to a different Bot API server, e.g. self-hosted telegram-bot-api,
+// a proxy or a test server. Endpoint is scheme and host with optional path prefix, without trailing slash.
+func WithAPIEndpoint(endpoint string) ClientOption {
+	return func(c *BaseClient) {
+		c.endpoint = strings.TrimRight(endpoint, "/")
+	}
+}
+
+// WithLocalMode tells client that Bot API server runs with --local flag.
+// In this mode getFile returns absolute paths on server file system and DownloadFile reads them directly.
+func WithLocalMode() ClientOption {
+	return func(c *BaseClient) {
+		c.localMode = true
+	}
+}
+
+func NewClient(apiToken string, longPollingTimeout int, opts ...ClientOption) *BaseClient {
+	c := &BaseClient{
 		tmpl:               template.Must(template.New("templates").Parse(templates)),
-		httpClient:         http.Client{},
+		transport:          newTransport(),
+		requestTimeout:     DefaultRequestTimeout,
+		endpoint:           DefaultAPIEndpoint,
 		apiToken:           apiToken,
 		longPollingTimeout: longPollingTimeout,
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.buildHTTPClient()
+	return c
 }
 
 type BaseClient struct {
 	tmpl               *template.Template
-	httpClient         http.Client
+	httpClient         *http.Client
+	transport          *http.Transport
+	roundTripper       http.RoundTripper
+	requestTimeout     time.Duration
+	endpoint           string
+	localMode          bool
 	apiToken           string
 	longPollingTimeout int
+	retryPolicy        RetryPolicy
+	rateLimiter        RateLimiter
+	migrationRetry     bool
+	onMigrate          func(fromChatID, toChatID int)
 }
 
 type params struct {
-	Token  string
-	Method string
+	Endpoint string
+	Token    string
+	Method   string
 }
 
 func (c BaseClient) makeUrl(method string) (string, error) {
 	urlBuffer := bytes.Buffer{}
 	err := c.tmpl.ExecuteTemplate(&urlBuffer, "bot_url", params{
-		Token:  c.apiToken,
-		Method: method,
+		Endpoint: c.endpoint,
+		Token:    c.apiToken,
+		Method:   method,
 	})
 	return urlBuffer.String(), err
 }
 
-func toJson(request interface{}) (io.Reader, error) {
+func toJson(request interface{}) ([]byte, error) {
 	reqBody := bytes.Buffer{}
 	if request != nil {
 		err := json.NewEncoder(&reqBody).Encode(request)
-		return &reqBody, err
+		return reqBody.Bytes(), err
 	}
-	return &reqBody, nil
+	return reqBody.Bytes(), nil
 }
 
-// makeRequest makes requests of method type with customizable request and result types
-func (c BaseClient) makeRequest(method string, request interface{}, result interface{}) (interface{}, error) {
-	url, err := c.makeUrl(method)
-	if err != nil {
-		return nil, err
+// bodyFunc makes a fresh request body for every attempt, so request can be repeated.
+// chatID is target chat of the attempt, it differs from original one when chat was migrated to supergroup.
+type bodyFunc func(chatID int) (body io.Reader, contentType string, err error)
+
+// apiCall describes single Bot API request
+type apiCall struct {
+	method string
+	// chatID is target chat of the request, zero if request is not addressed to a chat
+	chatID int
+	body   bodyFunc
+	// timeout limits every attempt, zero means no limit
+	timeout time.Duration
+	// oneShot is set when body can be made only once, so request cannot be repeated
+	oneShot bool
+	// messages is number of messages request sends, rate limiter is waited for every one of them
+	messages int
+}
+
+type chatTarget interface {
+	targetChatID() int
+}
+
+func requestChatID(request interface{}) int {
+	if target, ok := request.(chatTarget); ok {
+		return target.targetChatID()
 	}
-	reqBody, err := toJson(request)
-	contentType := "application/json"
-	ret, err := c.doPostRequest(url, contentType, reqBody, result)
-	return ret, err
+	return 0
 }
 
-func makeFormBody(formData *map[string]io.Reader) (io.Reader, string, error) {
-	formBytes := bytes.Buffer{}
-	formWriter := multipart.NewWriter(&formBytes)
-	for fieldName, reader := range *formData {
-		var writer io.Writer
-		var err error
-		if reader, ok := reader.(*os.File); ok {
-			writer, err = formWriter.CreateFormFile(fieldName, reader.Name())
-		} else {
-			writer, err = formWriter.CreateFormField(fieldName)
-		}
-		if err != nil {
-			return nil, "", err
+// messageBatch is request sending several messages at once, e.g. media group
+type messageBatch interface {
+	messageCount() int
+}
+
+// requestMessages returns number of messages request sends if it is send method, at least one
+func requestMessages(request interface{}) int {
+	if batch, ok := request.(messageBatch); ok && batch.messageCount() > 1 {
+		return batch.messageCount()
+	}
+	return 1
+}
+
+func jsonBody(data []byte, originalChatID int) bodyFunc {
+	return func(chatID int) (io.Reader, string, error) {
+		if chatID == originalChatID {
+			return bytes.NewReader(data), "application/json", nil
 		}
-		if _, err := io.Copy(writer, reader); err != nil {
+		fields := map[string]json.RawMessage{}
+		if err := json.Unmarshal(data, &fields); err != nil {
 			return nil, "", err
 		}
+		fields["chat_id"] = json.RawMessage(strconv.Itoa(chatID))
+		patched, err := json.Marshal(fields)
+		return bytes.NewReader(patched), "application/json", err
 	}
-	if err := formWriter.Close(); err != nil {
-		logrus.WithError(err).Warn("closing resource")
-	}
-	return &formBytes, formWriter.FormDataContentType(), nil
 }
 
-func (c BaseClient) doFormRequest(
-	method string, formData *map[string]io.Reader, result interface{}) (ret interface{}, err error) {
+// makeRequestContext makes requests of method type with customizable request and result types
+func (c BaseClient) makeRequestContext(
+	ctx context.Context, method string, request interface{}, result interface{}) (interface{}, error) {
 
-	url, err := c.makeUrl(method)
+	reqBody, err := toJson(request)
 	if err != nil {
 		return nil, err
 	}
-
-	bodyReader, contentType, err := makeFormBody(formData)
-	if err != nil {
-		return nil, err
+	chatID := requestChatID(request)
+	timeout := c.requestTimeout
+	if updatesRequest, ok := request.(GetUpdatesRequest); ok && timeout > 0 {
+		timeout += time.Duration(updatesRequest.Timeout) * time.Second
 	}
-
-	ret, err = c.doPostRequest(url, contentType, bodyReader, result)
+	ret, err := c.doPostRequest(ctx, apiCall{
+		method:   method,
+		chatID:   chatID,
+		body:     jsonBody(reqBody, chatID),
+		timeout:  timeout,
+		messages: requestMessages(request),
+	}, result)
 	return ret, err
 }
 
@@ -118,10 +188,72 @@ type ResponseWrapper struct {
 	Result      interface{}        `json:"result"`
 }
 
-func (c BaseClient) doPostRequest(
-	url string, contentType string, bodyReader io.Reader, result interface{}) (interface{}, error) {
+// doPostRequest posts request body repeating it according to retry policy,
+// failures reported by Bot API are returned as *APIError
+func (c BaseClient) doPostRequest(ctx context.Context, call apiCall, result interface{}) (interface{}, error) {
+	url, err := c.makeUrl(call.method)
+	if err != nil {
+		return nil, err
+	}
+	migrated := false
+	for attempt := 1; ; attempt++ {
+		if c.rateLimiter != nil && isSendMethod(call.method) {
+			// zero messages still is a request to wait for
+			for i := 0; i < call.messages || i == 0; i++ {
+				if err := c.rateLimiter.Wait(ctx, call.chatID); err != nil {
+					return nil, err
+				}
+			}
+		}
+		ret, err := c.postOnce(ctx, call, url, result)
+		if err == nil {
+			return ret, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		if apiErr, ok := AsAPIError(err); ok && apiErr.MigratedTo() != 0 && call.chatID != 0 {
+			if c.onMigrate != nil {
+				c.onMigrate(call.chatID, apiErr.MigratedTo())
+			}
+			if c.migrationRetry && !migrated && !call.oneShot {
+				logrus.Infof("chat %d migrated to %d, repeating %s", call.chatID, apiErr.MigratedTo(), call.method)
+				call.chatID = apiErr.MigratedTo()
+				migrated = true
+				continue
+			}
+		}
+		if call.oneShot {
+			return nil, err
+		}
+		delay, retry := c.retryPolicy.backoff(call.method, attempt, err)
+		if !retry {
+			return nil, err
+		}
+		logrus.WithError(err).Warnf("%s attempt %d failed, retrying in %s", call.method, attempt, delay)
+		if err := sleepContext(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
 
-	resp, err := c.httpClient.Post(url, contentType, bodyReader)
+func (c BaseClient) postOnce(ctx context.Context, call apiCall, url string, result interface{}) (interface{}, error) {
+	method := call.method
+	if call.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, call.timeout)
+		defer cancel()
+	}
+	bodyReader, contentType, err := call.body(call.chatID)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bodyReader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
 	if err != nil {
 		return nil, err
 	}
@@ -130,33 +262,51 @@ func (c BaseClient) doPostRequest(
 	}
 	defer closeOrWarn(resp.Body)
 
-	var respWrapper ResponseWrapper
-	if result != nil {
-		respWrapper = ResponseWrapper{
-			Result: result,
-		}
-	}
-	// checking ErrorCode for zero to allow long polling pass through with Ok==false
-	if respWrapper.ErrorCode != 0 && !respWrapper.Ok {
-		return nil, fmt.Errorf("status code %d, error: %s", respWrapper.ErrorCode, respWrapper.Description)
+	respWrapper := ResponseWrapper{
+		Result: result,
 	}
 	err = json.NewDecoder(resp.Body).Decode(&respWrapper)
 	if err != nil {
+		if resp.StatusCode != http.StatusOK {
+			// not a Bot API response, e.g. proxy or load balancer error page
+			return nil, &APIError{
+				Method:      method,
+				StatusCode:  resp.StatusCode,
+				ErrorCode:   resp.StatusCode,
+				Description: resp.Status,
+			}
+		}
 		return nil, err
 	}
 	if !respWrapper.Ok {
-		return nil, fmt.Errorf(
-			"response status is not OK: %d - %s", respWrapper.ErrorCode, respWrapper.Description)
+		return nil, &APIError{
+			Method:      method,
+			StatusCode:  resp.StatusCode,
+			ErrorCode:   respWrapper.ErrorCode,
+			Description: respWrapper.Description,
+			Parameters:  respWrapper.Parameters,
+		}
 	}
-	return respWrapper.Result, err
+	return respWrapper.Result, nil
 }
 
 func (c BaseClient) DownloadFile(filePath string) ([]byte, error) {
+	return c.DownloadFileContext(context.Background(), filePath)
+}
+
+func (c BaseClient) DownloadFileContext(ctx context.Context, filePath string) ([]byte, error) {
+	if c.localMode && filepath.IsAbs(filePath) {
+		return ioutil.ReadFile(filePath)
+	}
 	url, err := c.makeFileURL(filePath)
 	if err != nil {
 		return nil, err
 	}
-	resp, err := c.httpClient.Get(url)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
 	if err != nil {
 		return nil, err
 	}
@@ -168,15 +318,17 @@ func (c BaseClient) DownloadFile(filePath string) ([]byte, error) {
 }
 
 type fileParams struct {
-	Token string
-	Path  string
+	Endpoint string
+	Token    string
+	Path     string
 }
 
 func (c BaseClient) makeFileURL(path string) (string, error) {
 	urlBuffer := bytes.Buffer{}
 	err := c.tmpl.ExecuteTemplate(&urlBuffer, "file_dl_url", fileParams{
-		Token: c.apiToken,
-		Path:  path,
+		Endpoint: c.endpoint,
+		Token:    c.apiToken,
+		Path:     path,
 	})
 	return urlBuffer.String(), err
 }
@@ -0,0 +1,90 @@
+package telegram
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// APIError is returned for every request Bot API answered with ok=false
+type APIError struct {
+	// Method is Bot API method name, e.g. sendMessage
+	Method string
+	// StatusCode is HTTP status of the response
+	StatusCode  int
+	ErrorCode   int
+	Description string
+	Parameters  ResponseParameters
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s: status code %d, error: %s", e.Method, e.ErrorCode, e.Description)
+}
+
+// RetryAfter is time to wait before repeating the request, zero if Bot API did not ask to wait
+func (e *APIError) RetryAfter() time.Duration {
+	return time.Duration(e.Parameters.RetryAfter) * time.Second
+}
+
+// MigratedTo returns ID of supergroup the chat was upgraded to, zero if it was not
+func (e *APIError) MigratedTo() int {
+	return e.Parameters.MigrateToChatID
+}
+
+// AsAPIError extracts *APIError from err
+func AsAPIError(err error) (*APIError, bool) {
+	apiErr, ok := err.(*APIError)
+	return apiErr, ok && apiErr != nil
+}
+
+// Descriptions Bot API uses for common failures. Matching is done by substring, ignoring case.
+const (
+	descriptionBotBlocked         = "bot was blocked by the user"
+	descriptionChatNotFound       = "chat not found"
+	descriptionMessageNotModified = "message is not modified"
+	descriptionCantParseEntities  = "can't parse entities"
+)
+
+func matchAPIError(err error, code int, description string) bool {
+	apiErr, ok := AsAPIError(err)
+	if !ok {
+		return false
+	}
+	if code != 0 && apiErr.ErrorCode != code {
+		return false
+	}
+	return strings.Contains(strings.ToLower(apiErr.Description), description)
+}
+
+// IsBotBlocked reports whether user blocked the bot, so messages to that user will never be delivered
+func IsBotBlocked(err error) bool {
+	return matchAPIError(err, http.StatusForbidden, descriptionBotBlocked)
+}
+
+// IsChatNotFound reports whether target chat does not exist or bot has no access to it
+func IsChatNotFound(err error) bool {
+	return matchAPIError(err, http.StatusBadRequest, descriptionChatNotFound)
+}
+
+// IsMessageNotModified reports whether edit request had the same content as the message already has
+func IsMessageNotModified(err error) bool {
+	return matchAPIError(err, http.StatusBadRequest, descriptionMessageNotModified)
+}
+
+// IsCantParseEntities reports whether Markdown or HTML in the message text is malformed
+func IsCantParseEntities(err error) bool {
+	return matchAPIError(err, http.StatusBadRequest, descriptionCantParseEntities)
+}
+
+// IsTooManyRequests reports whether request was rejected by flood control
+func IsTooManyRequests(err error) bool {
+	apiErr, ok := AsAPIError(err)
+	return ok && apiErr.ErrorCode == http.StatusTooManyRequests
+}
+
+// IsChatMigrated reports whether group was upgraded to supergroup, new chat ID is in APIError.MigratedTo
+func IsChatMigrated(err error) bool {
+	apiErr, ok := AsAPIError(err)
+	return ok && apiErr.MigratedTo() != 0
+}
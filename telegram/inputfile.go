@@ -0,0 +1,192 @@
+package telegram
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// InputFile is file to send: file_id of a file already stored by Telegram, URL for Telegram to fetch,
+// or content uploaded from local file or any reader. Use InputFileX constructors to make one.
+type InputFile struct {
+	fileID string
+	url    string
+	path   string
+	name   string
+	reader io.Reader
+	data   []byte
+	// attach is name of multipart field the file is uploaded with
+	attach string
+}
+
+// InputFileID resends file already stored on Telegram servers
+func InputFileID(fileID string) InputFile {
+	return InputFile{fileID: fileID}
+}
+
+// InputFileURL makes Telegram download file from url
+func InputFileURL(url string) InputFile {
+	return InputFile{url: url}
+}
+
+// InputFilePath uploads local file, it is opened only when request is sent
+func InputFilePath(path string) InputFile {
+	return InputFile{path: path, name: filepath.Base(path)}
+}
+
+// InputFileReader uploads content of reader as file with given name.
+// Request with reader which is not io.Seeker cannot be repeated on failure.
+func InputFileReader(name string, reader io.Reader) InputFile {
+	return InputFile{name: name, reader: reader}
+}
+
+// InputFileBytes uploads data as file with given name
+func InputFileBytes(name string, data []byte) InputFile {
+	return InputFile{name: name, data: data}
+}
+
+func inputFileAttach(fieldName string) InputFile {
+	return InputFile{attach: fieldName}
+}
+
+// IsZero reports whether file was not set
+func (f InputFile) IsZero() bool {
+	return f.fileID == "" && f.url == "" && f.attach == "" && !f.NeedsUpload()
+}
+
+// NeedsUpload reports whether file content has to be sent with multipart request
+func (f InputFile) NeedsUpload() bool {
+	return f.path != "" || f.reader != nil || f.data != nil
+}
+
+var errInputFileUpload = errors.New("input file needs multipart upload and cannot be sent as JSON")
+
+// MarshalJSON writes file_id or URL, files needing upload are sent with multipart requests only
+func (f InputFile) MarshalJSON() ([]byte, error) {
+	if f.NeedsUpload() {
+		return nil, errInputFileUpload
+	}
+	if f.fileID != "" {
+		return json.Marshal(f.fileID)
+	}
+	if f.attach != "" {
+		return json.Marshal("attach://" + f.attach)
+	}
+	return json.Marshal(f.url)
+}
+
+// check reports problems which would otherwise surface only in the middle of upload
+func (f InputFile) check() error {
+	if f.path == "" {
+		return nil
+	}
+	_, err := os.Stat(f.path)
+	return err
+}
+
+// formReader makes reader for multipart form field, uploads get name so they are sent as files
+func (f InputFile) formReader() io.Reader {
+	switch {
+	case f.path != "":
+		return &lazyFile{path: f.path}
+	case f.data != nil:
+		return namedReadSeeker{ReadSeeker: bytes.NewReader(f.data), name: f.name}
+	case f.reader != nil:
+		if seeker, ok := f.reader.(io.ReadSeeker); ok {
+			return namedReadSeeker{ReadSeeker: seeker, name: f.name}
+		}
+		return namedReader{Reader: f.reader, name: f.name}
+	case f.fileID != "":
+		return strings.NewReader(f.fileID)
+	default:
+		return strings.NewReader(f.url)
+	}
+}
+
+func anyUpload(files ...InputFile) bool {
+	for _, f := range files {
+		if f.NeedsUpload() {
+			return true
+		}
+	}
+	return false
+}
+
+func checkFiles(files ...InputFile) error {
+	for _, f := range files {
+		if err := f.check(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type namedReader struct {
+	io.Reader
+	name string
+}
+
+func (r namedReader) Name() string {
+	return r.name
+}
+
+type namedReadSeeker struct {
+	io.ReadSeeker
+	name string
+}
+
+func (r namedReadSeeker) Name() string {
+	return r.name
+}
+
+// lazyFile opens file on first read, so nothing is left open if request is not sent
+type lazyFile struct {
+	path string
+	file *os.File
+}
+
+func (f *lazyFile) Name() string {
+	return filepath.Base(f.path)
+}
+
+func (f *lazyFile) open() error {
+	if f.file != nil {
+		return nil
+	}
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	f.file = file
+	return nil
+}
+
+func (f *lazyFile) Read(p []byte) (int, error) {
+	if err := f.open(); err != nil {
+		return 0, err
+	}
+	return f.file.Read(p)
+}
+
+func (f *lazyFile) Seek(offset int64, whence int) (int64, error) {
+	if f.file == nil && offset == 0 && whence != io.SeekEnd {
+		return 0, nil
+	}
+	if err := f.open(); err != nil {
+		return 0, err
+	}
+	return f.file.Seek(offset, whence)
+}
+
+func (f *lazyFile) Close() error {
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
@@ -0,0 +1,138 @@
+package telegram
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInputFileSelectsJSONOrMultipart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inputfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "photo.jpg")
+	if err := ioutil.WriteFile(path, []byte("jpeg"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		file      InputFile
+		multipart bool
+		sent      string
+	}{
+		{"file_id", InputFileID("AgADBAAD"), false, `"photo":"AgADBAAD"`},
+		{"URL", InputFileURL("https://example.com/cat.jpg"), false, `"photo":"https://example.com/cat.jpg"`},
+		{"path", InputFilePath(path), true, "jpeg"},
+		{"reader", InputFileReader("cat.jpg", bytes.NewBufferString("from reader")), true, "from reader"},
+		{"bytes", InputFileBytes("cat.jpg", []byte("from bytes")), true, "from bytes"},
+	}
+	for _, test := range tests {
+		server := &recordingServer{}
+		client, closeAPI := newRecordingClient(t, server)
+		request := SendPhotoRequest{Photo: test.file}
+		request.ChatID = 1
+		_, err := client.SendPhoto(request)
+		closeAPI()
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		sent := server.requests[0]
+		isMultipart := strings.HasPrefix(sent.contentType, "multipart/form-data")
+		if isMultipart != test.multipart {
+			t.Errorf("%s: sent as %s", test.name, sent.contentType)
+			continue
+		}
+		if test.multipart && sent.fields["photo"] != test.sent {
+			t.Errorf("%s: uploaded %q, want %q", test.name, sent.fields["photo"], test.sent)
+		}
+		if !test.multipart && !strings.Contains(sent.fields["json"], test.sent) {
+			t.Errorf("%s: sent %s, want it to contain %s", test.name, sent.fields["json"], test.sent)
+		}
+	}
+}
+
+func TestInputFileNeedingUploadIsNotMarshaled(t *testing.T) {
+	if _, err := InputFileBytes("a", []byte("x")).MarshalJSON(); err == nil {
+		t.Fatal("file needing upload was marshaled to JSON")
+	}
+}
+
+func TestInputFileMissingPathFailsBeforeUpload(t *testing.T) {
+	server := &recordingServer{}
+	client, closeAPI := newRecordingClient(t, server)
+	defer closeAPI()
+	request := SendPhotoRequest{Photo: InputFilePath("/nonexistent/photo.jpg")}
+	request.ChatID = 1
+	if _, err := client.SendPhoto(request); !os.IsNotExist(err) {
+		t.Fatalf("err = %v, want not exist", err)
+	}
+	if len(server.requests) != 0 {
+		t.Fatal("request was sent for missing file")
+	}
+}
+
+func TestLazyFileOpensOnFirstReadAndCloses(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inputfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "doc.txt")
+	if err := ioutil.WriteFile(path, []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file := &lazyFile{path: path}
+	if offset, err := file.Seek(0, io.SeekCurrent); err != nil || offset != 0 || file.file != nil {
+		t.Fatalf("seek to start opened file: offset %d, err %v", offset, err)
+	}
+	data, err := ioutil.ReadAll(file)
+	if err != nil || string(data) != "content" || file.file == nil {
+		t.Fatalf("read %q, %v", data, err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadAll(file); string(data) != "content" {
+		t.Fatalf("read %q after rewind", data)
+	}
+	if err := file.Close(); err != nil || file.file != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("second close: %v", err)
+	}
+	if file.Name() != "doc.txt" {
+		t.Fatalf("name = %q", file.Name())
+	}
+}
+
+func TestUploadClosesLazyFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inputfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "doc.txt")
+	if err := ioutil.WriteFile(path, []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file := &lazyFile{path: path}
+	form := &streamingForm{parts: []formPart{{fieldName: "document", fileName: "doc.txt", reader: file}}}
+	body, _, err := form.body(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(body); err != nil {
+		t.Fatal(err)
+	}
+	form.close()
+	if file.file != nil {
+		t.Fatal("file opened for upload was left open")
+	}
+}
@@ -0,0 +1,26 @@
+package telegram
+
+// WithMigrationRetry makes client repeat request once against new chat ID
+// when Bot API reports that group was upgraded to supergroup
+func WithMigrationRetry() ClientOption {
+	return func(c *BaseClient) {
+		c.migrationRetry = true
+	}
+}
+
+// WithMigrationHandler registers function called every time Bot API reports
+// that target chat of a request was migrated to supergroup with new chat ID.
+// Handlers given by several options are all called in order they were given.
+func WithMigrationHandler(handler func(fromChatID, toChatID int)) ClientOption {
+	return func(c *BaseClient) {
+		previous := c.onMigrate
+		if previous == nil {
+			c.onMigrate = handler
+			return
+		}
+		c.onMigrate = func(fromChatID, toChatID int) {
+			previous(fromChatID, toChatID)
+			handler(fromChatID, toChatID)
+		}
+	}
+}
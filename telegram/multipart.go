@@ -0,0 +1,201 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime/multipart"
+	"sort"
+	"strconv"
+)
+
+// UploadProgressFunc receives number of request body bytes sent so far
+type UploadProgressFunc func(sent int64)
+
+type uploadProgressKey struct{}
+
+// ContextWithUploadProgress makes file uploads done with ctx report progress to fn.
+// fn is called from the goroutine streaming request body.
+func ContextWithUploadProgress(ctx context.Context, fn UploadProgressFunc) context.Context {
+	return context.WithValue(ctx, uploadProgressKey{}, fn)
+}
+
+func uploadProgress(ctx context.Context) UploadProgressFunc {
+	fn, _ := ctx.Value(uploadProgressKey{}).(UploadProgressFunc)
+	return fn
+}
+
+var errBodyConsumed = errors.New("multipart body is not seekable and was already sent")
+
+type fileNamer interface {
+	Name() string
+}
+
+type formPart struct {
+	fieldName string
+	// fileName is set for file uploads
+	fileName string
+	reader   io.Reader
+	// offset is position of seekable reader before upload, body is rewound to it on repeat
+	offset int64
+}
+
+// formParts orders form fields by name putting files last,
+// so small fields are already there when Bot API starts receiving files
+func formParts(formData *map[string]io.Reader) ([]formPart, error) {
+	parts := make([]formPart, 0, len(*formData))
+	for fieldName, reader := range *formData {
+		part := formPart{fieldName: fieldName, reader: reader}
+		if named, ok := reader.(fileNamer); ok {
+			part.fileName = named.Name()
+		}
+		if seeker, ok := reader.(io.Seeker); ok {
+			offset, err := seeker.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return nil, err
+			}
+			part.offset = offset
+		}
+		parts = append(parts, part)
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		if (parts[i].fileName == "") != (parts[j].fileName == "") {
+			return parts[i].fileName == ""
+		}
+		return parts[i].fieldName < parts[j].fieldName
+	})
+	return parts, nil
+}
+
+func replayable(parts []formPart) bool {
+	for _, part := range parts {
+		if _, ok := part.reader.(io.Seeker); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (p formPart) rewind() error {
+	seeker, ok := p.reader.(io.Seeker)
+	if !ok {
+		return errBodyConsumed
+	}
+	_, err := seeker.Seek(p.offset, io.SeekStart)
+	return err
+}
+
+// streamingForm streams parts through a pipe instead of buffering them in memory
+type streamingForm struct {
+	parts    []formPart
+	progress UploadProgressFunc
+	// pipe and done belong to the last attempt
+	pipe *io.PipeReader
+	done chan struct{}
+}
+
+// body makes request body for the next attempt, see bodyFunc
+func (f *streamingForm) body(chatID int) (io.Reader, string, error) {
+	if f.pipe != nil {
+		f.wait()
+		for _, part := range f.parts {
+			if err := part.rewind(); err != nil {
+				return nil, "", err
+			}
+		}
+	}
+
+	pipeReader, pipeWriter := io.Pipe()
+	var out io.Writer = pipeWriter
+	if f.progress != nil {
+		out = &progressWriter{writer: pipeWriter, progress: f.progress}
+	}
+	formWriter := multipart.NewWriter(out)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := writeFormParts(formWriter, f.parts, chatID)
+		if err == nil {
+			err = formWriter.Close()
+		}
+		pipeWriter.CloseWithError(err)
+	}()
+	f.pipe, f.done = pipeReader, done
+	return pipeReader, formWriter.FormDataContentType(), nil
+}
+
+// wait stops writing of the last attempt body, so parts are not read after request is done
+func (f *streamingForm) wait() {
+	if f.pipe == nil {
+		return
+	}
+	closeOrWarn(f.pipe)
+	<-f.done
+}
+
+// close finishes the last attempt and closes files opened for upload
+func (f *streamingForm) close() {
+	f.wait()
+	for _, part := range f.parts {
+		if file, ok := part.reader.(*lazyFile); ok {
+			closeOrWarn(file)
+		}
+	}
+}
+
+func writeFormParts(formWriter *multipart.Writer, parts []formPart, chatID int) error {
+	for _, part := range parts {
+		var writer io.Writer
+		var err error
+		if part.fileName != "" {
+			writer, err = formWriter.CreateFormFile(part.fieldName, part.fileName)
+		} else {
+			writer, err = formWriter.CreateFormField(part.fieldName)
+		}
+		if err != nil {
+			return err
+		}
+		if part.fieldName == "chat_id" && chatID != 0 {
+			_, err = io.WriteString(writer, strconv.Itoa(chatID))
+		} else {
+			_, err = io.Copy(writer, part.reader)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type progressWriter struct {
+	writer   io.Writer
+	progress UploadProgressFunc
+	sent     int64
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.sent += int64(n)
+	w.progress(w.sent)
+	return n, err
+}
+
+func (c BaseClient) doFormRequestContext(ctx context.Context,
+	method string, chatID int, messages int, formData *map[string]io.Reader, result interface{}) (ret interface{}, err error) {
+
+	parts, err := formParts(formData)
+	if err != nil {
+		return nil, err
+	}
+
+	form := &streamingForm{parts: parts, progress: uploadProgress(ctx)}
+	defer form.close()
+	ret, err = c.doPostRequest(ctx, apiCall{
+		method:   method,
+		chatID:   chatID,
+		body:     form.body,
+		oneShot:  !replayable(parts),
+		messages: messages,
+	}, result)
+	return ret, err
+}
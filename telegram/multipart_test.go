@@ -0,0 +1,198 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordedRequest is request received by recordingServer, fields hold multipart form or top level JSON keys
+type recordedRequest struct {
+	method      string
+	contentType string
+	size        int
+	fields      map[string]string
+	fieldOrder  []string
+	fileNames   map[string]string
+}
+
+// recordingServer records requests and answers them with responses in order, the last one repeats
+type recordingServer struct {
+	mu        sync.Mutex
+	requests  []recordedRequest
+	responses []string
+	statuses  []int
+}
+
+func (s *recordingServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	data, _ := ioutil.ReadAll(r.Body)
+	request := recordedRequest{
+		method:      r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:],
+		contentType: r.Header.Get("Content-Type"),
+		size:        len(data),
+		fields:      map[string]string{},
+		fileNames:   map[string]string{},
+	}
+	mediaType, params, _ := mime.ParseMediaType(request.contentType)
+	if mediaType == "multipart/form-data" {
+		reader := multipart.NewReader(strings.NewReader(string(data)), params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err != nil {
+				break
+			}
+			content, _ := ioutil.ReadAll(part)
+			request.fields[part.FormName()] = string(content)
+			request.fieldOrder = append(request.fieldOrder, part.FormName())
+			if part.FileName() != "" {
+				request.fileNames[part.FormName()] = part.FileName()
+			}
+		}
+	} else {
+		request.fields["json"] = string(data)
+	}
+	s.mu.Lock()
+	attempt := len(s.requests)
+	s.requests = append(s.requests, request)
+	s.mu.Unlock()
+	status, response := http.StatusOK, `{"ok":true,"result":{"message_id":1,"date":0,"chat":{"id":1,"type":"private"}}}`
+	if attempt < len(s.responses) {
+		status, response = s.statuses[attempt], s.responses[attempt]
+	}
+	w.WriteHeader(status)
+	_, _ = io.WriteString(w, response)
+}
+
+// failFirst makes the first attempt fail with status and Bot API response
+func (s *recordingServer) failFirst(status int, response string) {
+	s.statuses = []int{status}
+	s.responses = []string{response}
+}
+
+func newRecordingClient(t *testing.T, server *recordingServer, opts ...ClientOption) (*BaseClient, func()) {
+	api := httptest.NewServer(server)
+	opts = append([]ClientOption{WithAPIEndpoint(api.URL)}, opts...)
+	return NewClient("token", 0, opts...), api.Close
+}
+
+func TestFormPartsPutFieldsBeforeFiles(t *testing.T) {
+	parts, err := formParts(&map[string]io.Reader{
+		"thumb":   namedReader{Reader: strings.NewReader("t"), name: "t.jpg"},
+		"caption": strings.NewReader("c"),
+		"chat_id": strings.NewReader("1"),
+		"audio":   namedReader{Reader: strings.NewReader("a"), name: "a.mp3"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order []string
+	for _, part := range parts {
+		order = append(order, part.fieldName)
+	}
+	if strings.Join(order, ",") != "caption,chat_id,audio,thumb" {
+		t.Fatalf("parts order = %v, want fields by name then files by name", order)
+	}
+}
+
+func TestUploadIsRepeatedWithFullBody(t *testing.T) {
+	for _, failure := range []struct {
+		status   int
+		response string
+	}{
+		{http.StatusTooManyRequests, `{"ok":false,"error_code":429,"description":"Too Many Requests"}`},
+		{http.StatusBadGateway, `bad gateway`},
+	} {
+		server := &recordingServer{}
+		server.failFirst(failure.status, failure.response)
+		client, closeAPI := newRecordingClient(t, server, WithRetryPolicy(RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond}))
+		var mu sync.Mutex
+		var reported []int64
+		ctx := ContextWithUploadProgress(context.Background(), func(sent int64) {
+			mu.Lock()
+			reported = append(reported, sent)
+			mu.Unlock()
+		})
+		content := strings.Repeat("file content ", 10000)
+		request := SendDocumentRequest{Document: InputFileBytes("report.txt", []byte(content))}
+		request.ChatID = 1
+		request.Caption = "report"
+		// 5xx is repeated only for methods safe to repeat, editing media is one
+		var err error
+		if failure.status == http.StatusTooManyRequests {
+			_, err = client.SendDocumentContext(ctx, request)
+		} else {
+			_, err = client.doFormRequestContext(ctx, "editMessageMedia", 1, 1, request.FillFormData(&map[string]io.Reader{}, nil), &Message{})
+		}
+		closeAPI()
+		if err != nil {
+			t.Fatalf("status %d: %v", failure.status, err)
+		}
+		if len(server.requests) != 2 {
+			t.Fatalf("status %d: %d attempts, want 2", failure.status, len(server.requests))
+		}
+		second := server.requests[1]
+		if second.fields["document"] != content || second.fileNames["document"] != "report.txt" {
+			t.Errorf("status %d: repeated upload has %d bytes of file, want %d", failure.status, len(second.fields["document"]), len(content))
+		}
+		if second.fields["caption"] != "report" || second.fields["chat_id"] != "1" {
+			t.Errorf("status %d: repeated upload fields = %v", failure.status, second.fieldOrder)
+		}
+		if strings.Join(second.fieldOrder, ",") != "caption,chat_id,document" {
+			t.Errorf("status %d: field order = %v", failure.status, second.fieldOrder)
+		}
+		mu.Lock()
+		last := reported[len(reported)-1]
+		mu.Unlock()
+		if last != int64(second.size) {
+			t.Errorf("status %d: progress reported %d bytes, body has %d", failure.status, last, second.size)
+		}
+	}
+}
+
+func TestOneShotUploadIsNotRepeated(t *testing.T) {
+	server := &recordingServer{}
+	server.failFirst(http.StatusTooManyRequests, `{"ok":false,"error_code":429,"description":"Too Many Requests"}`)
+	client, closeAPI := newRecordingClient(t, server, WithRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}))
+	defer closeAPI()
+	request := SendDocumentRequest{Document: InputFileReader("stream.txt", ioutil.NopCloser(strings.NewReader("data")))}
+	request.ChatID = 1
+	if _, err := client.SendDocument(request); err == nil {
+		t.Fatal("failed upload from reader which cannot be rewound succeeded")
+	}
+	if len(server.requests) != 1 {
+		t.Fatalf("%d attempts, want 1", len(server.requests))
+	}
+}
+
+type failingReader struct {
+	sent bool
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.sent {
+		return 0, errors.New("disk gone")
+	}
+	r.sent = true
+	return copy(p, "partial"), nil
+}
+
+func TestUploadReaderErrorFailsRequest(t *testing.T) {
+	server := &recordingServer{}
+	client, closeAPI := newRecordingClient(t, server)
+	defer closeAPI()
+	request := SendDocumentRequest{Document: InputFileReader("broken.txt", &failingReader{})}
+	request.ChatID = 1
+	_, err := client.SendDocument(request)
+	if err == nil || !strings.Contains(err.Error(), "disk gone") {
+		t.Fatalf("err = %v, want reader error", err)
+	}
+}
@@ -0,0 +1,175 @@
+package telegram
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimiter paces outgoing messages to stay within Bot API limits
+type RateLimiter interface {
+	// Wait blocks until message to chatID may be sent or ctx is done.
+	// chatID is zero for requests which are not addressed to a chat.
+	Wait(ctx context.Context, chatID int) error
+}
+
+// Rate is at most Count requests per Period, zero Count means unlimited
+type Rate struct {
+	Count  int
+	Period time.Duration
+}
+
+// RateLimits are limits enforced by limiter from NewRateLimiter
+type RateLimits struct {
+	// Global limits messages to all chats together
+	Global Rate
+	// PrivateChat limits messages to a single user
+	PrivateChat Rate
+	// Group limits messages to a single group, supergroup or channel
+	Group Rate
+}
+
+// DefaultRateLimits are limits documented in Bot API FAQ
+func DefaultRateLimits() RateLimits {
+	return RateLimits{
+		Global:      Rate{Count: 30, Period: time.Second},
+		PrivateChat: Rate{Count: 1, Period: time.Second},
+		Group:       Rate{Count: 20, Period: time.Minute},
+	}
+}
+
+// WithRateLimiter makes client wait for limiter before every message sent
+func WithRateLimiter(limiter RateLimiter) ClientOption {
+	return func(c *BaseClient) {
+		c.rateLimiter = limiter
+	}
+}
+
+// isSendMethod reports whether method sends a message and so is subject to rate limits
+func isSendMethod(method string) bool {
+	if method == "sendChatAction" {
+		return false
+	}
+	return strings.HasPrefix(method, "send") || method == "forwardMessage"
+}
+
+// NewRateLimiter creates limiter enforcing global and per chat limits using sliding windows
+func NewRateLimiter(limits RateLimits) RateLimiter {
+	return &windowLimiter{
+		limits: limits,
+		global: &window{rate: limits.Global},
+		chats:  map[int]*window{},
+	}
+}
+
+type windowLimiter struct {
+	mu          sync.Mutex
+	limits      RateLimits
+	global      *window
+	chats       map[int]*window
+	lastCleanup time.Time
+}
+
+func (l *windowLimiter) Wait(ctx context.Context, chatID int) error {
+	for {
+		delay := l.reserve(chatID, time.Now())
+		if delay <= 0 {
+			return nil
+		}
+		if err := sleepContext(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// reserve takes a slot and returns zero if both global and chat windows have one,
+// otherwise returns time to wait before trying again
+func (l *windowLimiter) reserve(chatID int, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cleanup(now)
+
+	available := l.global.available(now)
+	chat := l.chatWindow(chatID)
+	if chat != nil {
+		if chatAvailable := chat.available(now); chatAvailable.After(available) {
+			available = chatAvailable
+		}
+	}
+	if available.After(now) {
+		return available.Sub(now)
+	}
+	l.global.take(now)
+	if chat != nil {
+		chat.take(now)
+	}
+	return 0
+}
+
+func (l *windowLimiter) chatWindow(chatID int) *window {
+	if chatID == 0 {
+		return nil
+	}
+	if w, ok := l.chats[chatID]; ok {
+		return w
+	}
+	rate := l.limits.PrivateChat
+	if chatID < 0 {
+		rate = l.limits.Group
+	}
+	w := &window{rate: rate}
+	l.chats[chatID] = w
+	return w
+}
+
+// cleanup forgets chats which were not written to for longer than their period
+func (l *windowLimiter) cleanup(now time.Time) {
+	if now.Sub(l.lastCleanup) < time.Minute {
+		return
+	}
+	l.lastCleanup = now
+	for chatID, w := range l.chats {
+		if w.idle(now) {
+			delete(l.chats, chatID)
+		}
+	}
+}
+
+// window keeps times of requests sent during the last period
+type window struct {
+	rate Rate
+	sent []time.Time
+}
+
+func (w *window) expire(now time.Time) {
+	i := 0
+	for i < len(w.sent) && now.Sub(w.sent[i]) >= w.rate.Period {
+		i++
+	}
+	w.sent = w.sent[i:]
+}
+
+// available returns time when next request may be sent
+func (w *window) available(now time.Time) time.Time {
+	if w.rate.Count <= 0 {
+		return now
+	}
+	w.expire(now)
+	if len(w.sent) < w.rate.Count {
+		return now
+	}
+	return w.sent[len(w.sent)-w.rate.Count].Add(w.rate.Period)
+}
+
+func (w *window) take(now time.Time) {
+	if w.rate.Count <= 0 {
+		return
+	}
+	w.sent = append(w.sent, now)
+}
+
+func (w *window) idle(now time.Time) bool {
+	w.expire(now)
+	return len(w.sent) == 0
+}
@@ -0,0 +1,120 @@
+package telegram
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIsSendMethod(t *testing.T) {
+	tests := map[string]bool{
+		"sendMessage":    true,
+		"sendPhoto":      true,
+		"forwardMessage": true,
+		// chat actions have no documented limit and must not use up message slots
+		"sendChatAction":  false,
+		"getUpdates":      false,
+		"editMessageText": false,
+	}
+	for method, want := range tests {
+		if got := isSendMethod(method); got != want {
+			t.Errorf("isSendMethod(%q) = %v, want %v", method, got, want)
+		}
+	}
+}
+
+func TestWindowLimiterReserve(t *testing.T) {
+	limiter := NewRateLimiter(RateLimits{
+		Global:      Rate{Count: 3, Period: time.Second},
+		PrivateChat: Rate{Count: 1, Period: time.Second},
+		Group:       Rate{Count: 2, Period: time.Minute},
+	}).(*windowLimiter)
+	now := time.Unix(1000, 0)
+
+	if delay := limiter.reserve(1, now); delay != 0 {
+		t.Fatalf("first message to chat delayed by %s", delay)
+	}
+	if delay := limiter.reserve(1, now); delay != time.Second {
+		t.Fatalf("second message to private chat delayed by %s, want 1s", delay)
+	}
+	if delay := limiter.reserve(-5, now); delay != 0 {
+		t.Fatalf("message to group delayed by %s", delay)
+	}
+	if delay := limiter.reserve(-5, now.Add(100*time.Millisecond)); delay != 0 {
+		t.Fatalf("second message to group delayed by %s", delay)
+	}
+	// global window is full now: 3 messages within a second
+	if delay := limiter.reserve(2, now.Add(200*time.Millisecond)); delay != 800*time.Millisecond {
+		t.Fatalf("message over global limit delayed by %s, want 800ms", delay)
+	}
+	// group allows 2 per minute, so the third waits for the first to leave the window
+	if delay := limiter.reserve(-5, now.Add(2*time.Second)); delay != 58*time.Second {
+		t.Fatalf("third message to group delayed by %s, want 58s", delay)
+	}
+	if delay := limiter.reserve(1, now.Add(time.Second)); delay != 0 {
+		t.Fatalf("message to private chat after period delayed by %s", delay)
+	}
+}
+
+func TestWindowLimiterZeroCountIsUnlimited(t *testing.T) {
+	limiter := NewRateLimiter(RateLimits{}).(*windowLimiter)
+	now := time.Unix(1000, 0)
+	for i := 0; i < 100; i++ {
+		if delay := limiter.reserve(1, now); delay != 0 {
+			t.Fatalf("unlimited limiter delayed message %d by %s", i, delay)
+		}
+	}
+}
+
+// countingLimiter counts waits for every chat without delaying anything
+type countingLimiter struct {
+	mu    sync.Mutex
+	waits map[int]int
+}
+
+func (l *countingLimiter) Wait(ctx context.Context, chatID int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.waits[chatID]++
+	return nil
+}
+
+func TestMediaGroupUsesRateLimitForEveryItem(t *testing.T) {
+	server := &recordingServer{}
+	server.statuses = []int{200, 200, 200}
+	server.responses = []string{`{"ok":true,"result":[]}`, `{"ok":true,"result":[]}`, `{"ok":true,"result":{"message_id":1}}`}
+	limiter := &countingLimiter{waits: map[int]int{}}
+	client, closeAPI := newRecordingClient(t, server, WithRateLimiter(limiter))
+	defer closeAPI()
+
+	byID := SendMediaGroupRequest{Media: []InputMedia{
+		{Type: InputMediaTypePhoto, Media: InputFileID("a")},
+		{Type: InputMediaTypePhoto, Media: InputFileID("b")},
+		{Type: InputMediaTypePhoto, Media: InputFileID("c")},
+	}}
+	byID.ChatID = 1
+	uploaded := SendMediaGroupRequest{Media: []InputMedia{
+		{Type: InputMediaTypePhoto, Media: InputFileBytes("a.jpg", []byte("a"))},
+		{Type: InputMediaTypePhoto, Media: InputFileBytes("b.jpg", []byte("b"))},
+	}}
+	uploaded.ChatID = 2
+	message := SendMessageRequest{}
+	message.ChatID = 3
+	message.Text = "hi"
+
+	if _, err := client.SendMediaGroup(byID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.SendMediaGroup(uploaded); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.SendMessage(message); err != nil {
+		t.Fatal(err)
+	}
+	for chatID, want := range map[int]int{1: 3, 2: 2, 3: 1} {
+		if got := limiter.waits[chatID]; got != want {
+			t.Errorf("chat %d waited for %d slots, want %d", chatID, got, want)
+		}
+	}
+}
@@ -1,8 +1,8 @@
 package telegram
 
 import (
+	"context"
 	"io"
-	"os"
 )
 
 type MeGetter interface {
@@ -44,7 +44,7 @@ type VideoNoteSender interface {
 }
 
 type MediaGroupSender interface {
-	SendMediaGroup(SendMediaGroupRequest) (*Message, error)
+	SendMediaGroup(SendMediaGroupRequest) ([]Message, error)
 }
 
 type LocationSender interface {
@@ -71,24 +71,36 @@ type UpdatesGetter interface {
 	GetUpdates(GetUpdatesRequest) (*[]Update, error)
 }
 
+type WebhookManager interface {
+	SetWebhook(SetWebhookRequest) (bool, error)
+	DeleteWebhook(DeleteWebhookRequest) (bool, error)
+	GetWebhookInfo() (*WebhookInfo, error)
+}
+
+type CommandsManager interface {
+	SetMyCommands(SetMyCommandsRequest) (bool, error)
+	GetMyCommands(GetMyCommandsRequest) ([]BotCommand, error)
+	DeleteMyCommands(DeleteMyCommandsRequest) (bool, error)
+}
+
 type FileGetter interface {
 	GetFile(GetFileRequest) (*File, error)
 }
 
 type ChatOperations interface {
-	SetChatPhoto(SetChatPhotoRequest) bool
-	DeleteChatPhoto(ChatRequest) bool
-	SetChatTitle(SetChatTitleRequest) bool
-	SetChatDescription(SetChatDescriptionRequest) bool
-	PinChatMessage(PinChatMessageRequest) bool
-	UnpinChatMessage(ChatRequest) bool
-	LeaveChat(ChatRequest) bool
-	GetChat(ChatRequest) Chat
-	GetChatAdministrators(ChatRequest) []ChatMember
-	GetChatMembersCount(ChatRequest) int
-	GetChatMember(GetChatMemberRequest) ChatMember
-	SetChatStickerSet(SetChatStickerSetRequest) bool
-	DeleteChatStickerSet(ChatRequest) bool
+	SetChatPhoto(SetChatPhotoRequest) (bool, error)
+	DeleteChatPhoto(ChatRequest) (bool, error)
+	SetChatTitle(SetChatTitleRequest) (bool, error)
+	SetChatDescription(SetChatDescriptionRequest) (bool, error)
+	PinChatMessage(PinChatMessageRequest) (bool, error)
+	UnpinChatMessage(ChatRequest) (bool, error)
+	LeaveChat(ChatRequest) (bool, error)
+	GetChat(ChatRequest) (*Chat, error)
+	GetChatAdministrators(ChatRequest) ([]ChatMember, error)
+	GetChatMembersCount(ChatRequest) (int, error)
+	GetChatMember(GetChatMemberRequest) (*ChatMember, error)
+	SetChatStickerSet(SetChatStickerSetRequest) (bool, error)
+	DeleteChatStickerSet(ChatRequest) (bool, error)
 }
 
 type MessageDeleter interface {
@@ -116,15 +128,26 @@ type Client interface {
 	LocationSender
 	ContactSender
 	PollSender
-	SendChatAction(SendChatActionRequest) bool
-	GetUserProfilePhotos(GetUserProfilePhotosRequest) UserProfilePhotos
+	MessageTextEditor
+	MessageReplyMarkupEditor
+	UpdatesGetter
+	WebhookManager
+	CommandsManager
+	SendChatAction(SendChatActionRequest) (bool, error)
+	GetUserProfilePhotos(GetUserProfilePhotosRequest) (*UserProfilePhotos, error)
 	ChatOperations
-	AnswerCallbackQuery(AnswerCallbackQueryRequest) bool
+	AnswerCallbackQuery(AnswerCallbackQueryRequest) error
 	InlineQueryAnswerer
 }
 
+var _ Client = BaseClient{}
+
 func (c BaseClient) GetMe() (*User, error) {
-	resp, err := c.makeRequest("getMe", nil, &User{})
+	return c.GetMeContext(context.Background())
+}
+
+func (c BaseClient) GetMeContext(ctx context.Context) (*User, error) {
+	resp, err := c.makeRequestContext(ctx, "getMe", nil, &User{})
 	if err != nil {
 		return nil, err
 	}
@@ -132,7 +155,11 @@ func (c BaseClient) GetMe() (*User, error) {
 }
 
 func (c BaseClient) SendMessage(request SendMessageRequest) (*Message, error) {
-	resp, err := c.makeRequest("sendMessage", request, &Message{})
+	return c.SendMessageContext(context.Background(), request)
+}
+
+func (c BaseClient) SendMessageContext(ctx context.Context, request SendMessageRequest) (*Message, error) {
+	resp, err := c.makeRequestContext(ctx, "sendMessage", request, &Message{})
 	if err != nil {
 		return nil, err
 	}
@@ -140,49 +167,374 @@ func (c BaseClient) SendMessage(request SendMessageRequest) (*Message, error) {
 }
 
 func (c BaseClient) ForwardMessage(request ForwardMessageRequest) (*Message, error) {
-	resp, err := c.makeRequest("forwardMessage", request, &Message{})
+	return c.ForwardMessageContext(context.Background(), request)
+}
+
+func (c BaseClient) ForwardMessageContext(ctx context.Context, request ForwardMessageRequest) (*Message, error) {
+	resp, err := c.makeRequestContext(ctx, "forwardMessage", request, &Message{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*Message), err
+}
+
+func (c BaseClient) SendPhoto(request SendPhotoRequest) (*Message, error) {
+	return c.SendPhotoContext(context.Background(), request)
+}
+
+func (c BaseClient) SendPhotoContext(ctx context.Context, request SendPhotoRequest) (*Message, error) {
+	resp, err := c.sendMediaContext(ctx, "sendPhoto", request, &Message{}, request.Photo)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*Message), err
+}
+
+func (c BaseClient) SendAudio(request SendAudioRequest) (*Message, error) {
+	return c.SendAudioContext(context.Background(), request)
+}
+
+func (c BaseClient) SendAudioContext(ctx context.Context, request SendAudioRequest) (*Message, error) {
+	resp, err := c.sendMediaContext(ctx, "sendAudio", request, &Message{}, request.Audio, request.thumb())
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*Message), err
+}
+
+// sendMediaContext sends request as JSON when files are file_id or URL, or as multipart upload otherwise
+func (c BaseClient) sendMediaContext(ctx context.Context,
+	method string, request FormDataFiller, result interface{}, files ...InputFile) (interface{}, error) {
+
+	if !anyUpload(files...) {
+		return c.makeRequestContext(ctx, method, request, result)
+	}
+	if err := checkFiles(files...); err != nil {
+		return nil, err
+	}
+	m := request.FillFormData(&map[string]io.Reader{}, nil)
+	return c.doFormRequestContext(ctx, method, requestChatID(request), requestMessages(request), m, result)
+}
+
+func (c BaseClient) SendDocument(request SendDocumentRequest) (*Message, error) {
+	return c.SendDocumentContext(context.Background(), request)
+}
+
+func (c BaseClient) SendDocumentContext(ctx context.Context, request SendDocumentRequest) (*Message, error) {
+	resp, err := c.sendMediaContext(ctx, "sendDocument", request, &Message{}, request.Document, request.thumb())
 	if err != nil {
 		return nil, err
 	}
 	return resp.(*Message), err
 }
 
-func (c BaseClient) SendPhoto(request SendPhotoRequest) (interface{}, error) {
-	file, err := os.Open(request.Photo)
+func (c BaseClient) SendVideo(request SendVideoRequest) (*Message, error) {
+	return c.SendVideoContext(context.Background(), request)
+}
+
+func (c BaseClient) SendVideoContext(ctx context.Context, request SendVideoRequest) (*Message, error) {
+	resp, err := c.sendMediaContext(ctx, "sendVideo", request, &Message{}, request.Video, request.thumb())
 	if err != nil {
 		return nil, err
 	}
-	defer closeOrWarn(file)
-	m := request.FillFormData(&map[string]io.Reader{}, file)
-	resp, err := c.doFormRequest("sendPhoto", m, &Message{})
+	return resp.(*Message), err
+}
+
+func (c BaseClient) SendAnimation(request SendAnimationRequest) (*Message, error) {
+	return c.SendAnimationContext(context.Background(), request)
+}
+
+func (c BaseClient) SendAnimationContext(ctx context.Context, request SendAnimationRequest) (*Message, error) {
+	resp, err := c.sendMediaContext(ctx, "sendAnimation", request, &Message{}, request.Animation, request.thumb())
 	if err != nil {
 		return nil, err
 	}
 	return resp.(*Message), err
 }
 
-func (c BaseClient) SendAudio(request SendAudioRequest) (interface{}, error) {
-	file, err := os.Open(request.Audio)
+func (c BaseClient) SendVoice(request SendVoiceRequest) (*Message, error) {
+	return c.SendVoiceContext(context.Background(), request)
+}
+
+func (c BaseClient) SendVoiceContext(ctx context.Context, request SendVoiceRequest) (*Message, error) {
+	resp, err := c.sendMediaContext(ctx, "sendVoice", request, &Message{}, request.Voice)
 	if err != nil {
 		return nil, err
 	}
-	defer closeOrWarn(file)
-	m := request.FillFormData(&map[string]io.Reader{}, file)
-	resp, err := c.doFormRequest("sendAudio", m, &Message{})
+	return resp.(*Message), err
+}
+
+func (c BaseClient) SendVideoNote(request SendVideoNoteRequest) (*Message, error) {
+	return c.SendVideoNoteContext(context.Background(), request)
+}
+
+func (c BaseClient) SendVideoNoteContext(ctx context.Context, request SendVideoNoteRequest) (*Message, error) {
+	resp, err := c.sendMediaContext(ctx, "sendVideoNote", request, &Message{}, request.VideoNote, request.thumb())
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*Message), err
+}
+
+func (c BaseClient) SendMediaGroup(request SendMediaGroupRequest) ([]Message, error) {
+	return c.SendMediaGroupContext(context.Background(), request)
+}
+
+func (c BaseClient) SendMediaGroupContext(ctx context.Context, request SendMediaGroupRequest) ([]Message, error) {
+	var messages = make([]Message, 0)
+	resp, err := c.sendMediaContext(ctx, "sendMediaGroup", request, &messages, request.files()...)
+	if err != nil {
+		return nil, err
+	}
+	return *resp.(*[]Message), err
+}
+
+func (c BaseClient) SendLocation(request SendLocationRequest) (*Message, error) {
+	return c.SendLocationContext(context.Background(), request)
+}
+
+func (c BaseClient) SendLocationContext(ctx context.Context, request SendLocationRequest) (*Message, error) {
+	resp, err := c.makeRequestContext(ctx, "sendLocation", request, &Message{})
 	if err != nil {
 		return nil, err
 	}
 	return resp.(*Message), err
 }
 
+func (c BaseClient) SendContact(request SendContactRequest) (*Message, error) {
+	return c.SendContactContext(context.Background(), request)
+}
+
+func (c BaseClient) SendContactContext(ctx context.Context, request SendContactRequest) (*Message, error) {
+	resp, err := c.makeRequestContext(ctx, "sendContact", request, &Message{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*Message), err
+}
+
+func (c BaseClient) SendPoll(request SendPollRequest) (*Message, error) {
+	return c.SendPollContext(context.Background(), request)
+}
+
+func (c BaseClient) SendPollContext(ctx context.Context, request SendPollRequest) (*Message, error) {
+	resp, err := c.makeRequestContext(ctx, "sendPoll", request, &Message{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*Message), err
+}
+
+func (c BaseClient) SendChatAction(request SendChatActionRequest) (bool, error) {
+	return c.SendChatActionContext(context.Background(), request)
+}
+
+func (c BaseClient) SendChatActionContext(ctx context.Context, request SendChatActionRequest) (bool, error) {
+	var b bool
+	resp, err := c.makeRequestContext(ctx, "sendChatAction", request, &b)
+	if err != nil {
+		return false, err
+	}
+	return *resp.(*bool), err
+}
+
+func (c BaseClient) GetUserProfilePhotos(request GetUserProfilePhotosRequest) (*UserProfilePhotos, error) {
+	return c.GetUserProfilePhotosContext(context.Background(), request)
+}
+
+func (c BaseClient) GetUserProfilePhotosContext(
+	ctx context.Context, request GetUserProfilePhotosRequest) (*UserProfilePhotos, error) {
+
+	resp, err := c.makeRequestContext(ctx, "getUserProfilePhotos", request, &UserProfilePhotos{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*UserProfilePhotos), err
+}
+
+func (c BaseClient) SetChatPhoto(request SetChatPhotoRequest) (bool, error) {
+	return c.SetChatPhotoContext(context.Background(), request)
+}
+
+func (c BaseClient) SetChatPhotoContext(ctx context.Context, request SetChatPhotoRequest) (bool, error) {
+	var b bool
+	resp, err := c.sendMediaContext(ctx, "setChatPhoto", request, &b, request.Photo)
+	if err != nil {
+		return false, err
+	}
+	return *resp.(*bool), err
+}
+
+func (c BaseClient) DeleteChatPhoto(request ChatRequest) (bool, error) {
+	return c.DeleteChatPhotoContext(context.Background(), request)
+}
+
+func (c BaseClient) DeleteChatPhotoContext(ctx context.Context, request ChatRequest) (bool, error) {
+	var b bool
+	resp, err := c.makeRequestContext(ctx, "deleteChatPhoto", request, &b)
+	if err != nil {
+		return false, err
+	}
+	return *resp.(*bool), err
+}
+
+func (c BaseClient) SetChatTitle(request SetChatTitleRequest) (bool, error) {
+	return c.SetChatTitleContext(context.Background(), request)
+}
+
+func (c BaseClient) SetChatTitleContext(ctx context.Context, request SetChatTitleRequest) (bool, error) {
+	var b bool
+	resp, err := c.makeRequestContext(ctx, "setChatTitle", request, &b)
+	if err != nil {
+		return false, err
+	}
+	return *resp.(*bool), err
+}
+
+func (c BaseClient) SetChatDescription(request SetChatDescriptionRequest) (bool, error) {
+	return c.SetChatDescriptionContext(context.Background(), request)
+}
+
+func (c BaseClient) SetChatDescriptionContext(ctx context.Context, request SetChatDescriptionRequest) (bool, error) {
+	var b bool
+	resp, err := c.makeRequestContext(ctx, "setChatDescription", request, &b)
+	if err != nil {
+		return false, err
+	}
+	return *resp.(*bool), err
+}
+
+func (c BaseClient) PinChatMessage(request PinChatMessageRequest) (bool, error) {
+	return c.PinChatMessageContext(context.Background(), request)
+}
+
+func (c BaseClient) PinChatMessageContext(ctx context.Context, request PinChatMessageRequest) (bool, error) {
+	var b bool
+	resp, err := c.makeRequestContext(ctx, "pinChatMessage", request, &b)
+	if err != nil {
+		return false, err
+	}
+	return *resp.(*bool), err
+}
+
+func (c BaseClient) UnpinChatMessage(request ChatRequest) (bool, error) {
+	return c.UnpinChatMessageContext(context.Background(), request)
+}
+
+func (c BaseClient) UnpinChatMessageContext(ctx context.Context, request ChatRequest) (bool, error) {
+	var b bool
+	resp, err := c.makeRequestContext(ctx, "unpinChatMessage", request, &b)
+	if err != nil {
+		return false, err
+	}
+	return *resp.(*bool), err
+}
+
+func (c BaseClient) LeaveChat(request ChatRequest) (bool, error) {
+	return c.LeaveChatContext(context.Background(), request)
+}
+
+func (c BaseClient) LeaveChatContext(ctx context.Context, request ChatRequest) (bool, error) {
+	var b bool
+	resp, err := c.makeRequestContext(ctx, "leaveChat", request, &b)
+	if err != nil {
+		return false, err
+	}
+	return *resp.(*bool), err
+}
+
+func (c BaseClient) SetChatStickerSet(request SetChatStickerSetRequest) (bool, error) {
+	return c.SetChatStickerSetContext(context.Background(), request)
+}
+
+func (c BaseClient) SetChatStickerSetContext(ctx context.Context, request SetChatStickerSetRequest) (bool, error) {
+	var b bool
+	resp, err := c.makeRequestContext(ctx, "setChatStickerSet", request, &b)
+	if err != nil {
+		return false, err
+	}
+	return *resp.(*bool), err
+}
+
+func (c BaseClient) DeleteChatStickerSet(request ChatRequest) (bool, error) {
+	return c.DeleteChatStickerSetContext(context.Background(), request)
+}
+
+func (c BaseClient) DeleteChatStickerSetContext(ctx context.Context, request ChatRequest) (bool, error) {
+	var b bool
+	resp, err := c.makeRequestContext(ctx, "deleteChatStickerSet", request, &b)
+	if err != nil {
+		return false, err
+	}
+	return *resp.(*bool), err
+}
+
+func (c BaseClient) GetChat(request ChatRequest) (*Chat, error) {
+	return c.GetChatContext(context.Background(), request)
+}
+
+func (c BaseClient) GetChatContext(ctx context.Context, request ChatRequest) (*Chat, error) {
+	resp, err := c.makeRequestContext(ctx, "getChat", request, &Chat{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*Chat), err
+}
+
+func (c BaseClient) GetChatAdministrators(request ChatRequest) ([]ChatMember, error) {
+	return c.GetChatAdministratorsContext(context.Background(), request)
+}
+
+func (c BaseClient) GetChatAdministratorsContext(ctx context.Context, request ChatRequest) ([]ChatMember, error) {
+	var members = make([]ChatMember, 0)
+	resp, err := c.makeRequestContext(ctx, "getChatAdministrators", request, &members)
+	if err != nil {
+		return nil, err
+	}
+	return *resp.(*[]ChatMember), err
+}
+
+func (c BaseClient) GetChatMembersCount(request ChatRequest) (int, error) {
+	return c.GetChatMembersCountContext(context.Background(), request)
+}
+
+func (c BaseClient) GetChatMembersCountContext(ctx context.Context, request ChatRequest) (int, error) {
+	var count int
+	resp, err := c.makeRequestContext(ctx, "getChatMembersCount", request, &count)
+	if err != nil {
+		return 0, err
+	}
+	return *resp.(*int), err
+}
+
+func (c BaseClient) GetChatMember(request GetChatMemberRequest) (*ChatMember, error) {
+	return c.GetChatMemberContext(context.Background(), request)
+}
+
+func (c BaseClient) GetChatMemberContext(ctx context.Context, request GetChatMemberRequest) (*ChatMember, error) {
+	resp, err := c.makeRequestContext(ctx, "getChatMember", request, &ChatMember{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*ChatMember), err
+}
+
 func (c BaseClient) AnswerCallbackQuery(request AnswerCallbackQueryRequest) error {
+	return c.AnswerCallbackQueryContext(context.Background(), request)
+}
+
+func (c BaseClient) AnswerCallbackQueryContext(ctx context.Context, request AnswerCallbackQueryRequest) error {
 	var b bool
-	_, err := c.makeRequest("answerCallbackQuery", request, &b)
+	_, err := c.makeRequestContext(ctx, "answerCallbackQuery", request, &b)
 	return err
 }
 
 func (c BaseClient) EditMessageText(request EditMessageTextRequest) (*Message, error) {
-	resp, err := c.makeRequest("editMessageText", request, &Message{})
+	return c.EditMessageTextContext(context.Background(), request)
+}
+
+func (c BaseClient) EditMessageTextContext(ctx context.Context, request EditMessageTextRequest) (*Message, error) {
+	resp, err := c.makeRequestContext(ctx, "editMessageText", request, &Message{})
 	if err != nil {
 		return nil, err
 	}
@@ -190,7 +542,13 @@ func (c BaseClient) EditMessageText(request EditMessageTextRequest) (*Message, e
 }
 
 func (c BaseClient) EditMessageReplyMarkup(request EditMessageReplyMarkupRequest) (*Message, error) {
-	resp, err := c.makeRequest("editMessageReplyMarkup", request, &Message{})
+	return c.EditMessageReplyMarkupContext(context.Background(), request)
+}
+
+func (c BaseClient) EditMessageReplyMarkupContext(
+	ctx context.Context, request EditMessageReplyMarkupRequest) (*Message, error) {
+
+	resp, err := c.makeRequestContext(ctx, "editMessageReplyMarkup", request, &Message{})
 	if err != nil {
 		return nil, err
 	}
@@ -198,16 +556,110 @@ func (c BaseClient) EditMessageReplyMarkup(request EditMessageReplyMarkupRequest
 }
 
 func (c BaseClient) GetUpdates(request GetUpdatesRequest) (*[]Update, error) {
+	return c.GetUpdatesContext(context.Background(), request)
+}
+
+// GetUpdatesContext fetches updates, cancelling ctx aborts long polling request in flight.
+// Long polling timeout given to NewClient is used unless request sets one.
+func (c BaseClient) GetUpdatesContext(ctx context.Context, request GetUpdatesRequest) (*[]Update, error) {
+	if request.Timeout == 0 {
+		request.Timeout = c.longPollingTimeout
+	}
 	var updates = make([]Update, 0)
-	resp, err := c.makeRequest("getUpdates", request, &updates)
+	resp, err := c.makeRequestContext(ctx, "getUpdates", request, &updates)
 	if err != nil {
 		return nil, err
 	}
 	return resp.(*[]Update), nil
 }
 
+func (c BaseClient) SetWebhook(request SetWebhookRequest) (bool, error) {
+	return c.SetWebhookContext(context.Background(), request)
+}
+
+// SetWebhookContext makes Telegram deliver updates to request.URL, getUpdates stops working until webhook is deleted
+func (c BaseClient) SetWebhookContext(ctx context.Context, request SetWebhookRequest) (bool, error) {
+	var b bool
+	resp, err := c.sendMediaContext(ctx, "setWebhook", request, &b, request.certificate())
+	if err != nil {
+		return false, err
+	}
+	return *resp.(*bool), err
+}
+
+func (c BaseClient) DeleteWebhook(request DeleteWebhookRequest) (bool, error) {
+	return c.DeleteWebhookContext(context.Background(), request)
+}
+
+func (c BaseClient) DeleteWebhookContext(ctx context.Context, request DeleteWebhookRequest) (bool, error) {
+	var b bool
+	resp, err := c.makeRequestContext(ctx, "deleteWebhook", request, &b)
+	if err != nil {
+		return false, err
+	}
+	return *resp.(*bool), err
+}
+
+func (c BaseClient) GetWebhookInfo() (*WebhookInfo, error) {
+	return c.GetWebhookInfoContext(context.Background())
+}
+
+func (c BaseClient) GetWebhookInfoContext(ctx context.Context) (*WebhookInfo, error) {
+	resp, err := c.makeRequestContext(ctx, "getWebhookInfo", nil, &WebhookInfo{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*WebhookInfo), err
+}
+
+func (c BaseClient) SetMyCommands(request SetMyCommandsRequest) (bool, error) {
+	return c.SetMyCommandsContext(context.Background(), request)
+}
+
+func (c BaseClient) SetMyCommandsContext(ctx context.Context, request SetMyCommandsRequest) (bool, error) {
+	if request.Commands == nil {
+		request.Commands = []BotCommand{}
+	}
+	var b bool
+	resp, err := c.makeRequestContext(ctx, "setMyCommands", request, &b)
+	if err != nil {
+		return false, err
+	}
+	return *resp.(*bool), err
+}
+
+func (c BaseClient) GetMyCommands(request GetMyCommandsRequest) ([]BotCommand, error) {
+	return c.GetMyCommandsContext(context.Background(), request)
+}
+
+func (c BaseClient) GetMyCommandsContext(ctx context.Context, request GetMyCommandsRequest) ([]BotCommand, error) {
+	var commands []BotCommand
+	resp, err := c.makeRequestContext(ctx, "getMyCommands", request, &commands)
+	if err != nil {
+		return nil, err
+	}
+	return *resp.(*[]BotCommand), err
+}
+
+func (c BaseClient) DeleteMyCommands(request DeleteMyCommandsRequest) (bool, error) {
+	return c.DeleteMyCommandsContext(context.Background(), request)
+}
+
+func (c BaseClient) DeleteMyCommandsContext(ctx context.Context, request DeleteMyCommandsRequest) (bool, error) {
+	var b bool
+	resp, err := c.makeRequestContext(ctx, "deleteMyCommands", request, &b)
+	if err != nil {
+		return false, err
+	}
+	return *resp.(*bool), err
+}
+
 func (c BaseClient) GetFile(request GetFileRequest) (*File, error) {
-	resp, err := c.makeRequest("getFile", request, &File{})
+	return c.GetFileContext(context.Background(), request)
+}
+
+func (c BaseClient) GetFileContext(ctx context.Context, request GetFileRequest) (*File, error) {
+	resp, err := c.makeRequestContext(ctx, "getFile", request, &File{})
 	if err != nil {
 		return nil, err
 	}
@@ -215,8 +667,12 @@ func (c BaseClient) GetFile(request GetFileRequest) (*File, error) {
 }
 
 func (c BaseClient) DeleteMessage(request DeleteMessageRequest) (bool, error) {
+	return c.DeleteMessageContext(context.Background(), request)
+}
+
+func (c BaseClient) DeleteMessageContext(ctx context.Context, request DeleteMessageRequest) (bool, error) {
 	var b bool
-	resp, err := c.makeRequest("deleteMessage", request, &b)
+	resp, err := c.makeRequestContext(ctx, "deleteMessage", request, &b)
 	if err != nil {
 		return false, err
 	}
@@ -224,8 +680,12 @@ func (c BaseClient) DeleteMessage(request DeleteMessageRequest) (bool, error) {
 }
 
 func (c BaseClient) AnswerInlineQuery(request AnswerInlineQueryRequest) (bool, error) {
+	return c.AnswerInlineQueryContext(context.Background(), request)
+}
+
+func (c BaseClient) AnswerInlineQueryContext(ctx context.Context, request AnswerInlineQueryRequest) (bool, error) {
 	var b bool
-	resp, err := c.makeRequest("answerInlineQuery", request, &b)
+	resp, err := c.makeRequestContext(ctx, "answerInlineQuery", request, &b)
 	if err != nil {
 		return false, err
 	}
@@ -0,0 +1,80 @@
+package telegram
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestSendMediaGroupAttachesUploadsByPartName(t *testing.T) {
+	server := &recordingServer{}
+	server.statuses = []int{200}
+	server.responses = []string{`{"ok":true,"result":[]}`}
+	client, closeAPI := newRecordingClient(t, server)
+	defer closeAPI()
+	thumb := InputFileBytes("thumb.jpg", []byte("thumb data"))
+	request := SendMediaGroupRequest{Media: []InputMedia{
+		{Type: InputMediaTypePhoto, Media: InputFileID("AgADBAAD")},
+		{Type: InputMediaTypePhoto, Media: InputFileBytes("cat.jpg", []byte("cat data"))},
+		{Type: InputMediaTypeVideo, Media: InputFileURL("https://example.com/v.mp4")},
+		{Type: InputMediaTypeVideo, Media: InputFileBytes("dog.mp4", []byte("dog data")), ThumbSource: ThumbSource{Thumb: &thumb}},
+	}}
+	request.ChatID = 1
+	if _, err := client.SendMediaGroup(request); err != nil {
+		t.Fatal(err)
+	}
+	sent := server.requests[0]
+	if !strings.HasPrefix(sent.contentType, "multipart/form-data") {
+		t.Fatalf("media group with uploads sent as %s", sent.contentType)
+	}
+	var media []struct {
+		Media string `json:"media"`
+		Thumb string `json:"thumb"`
+	}
+	if err := json.Unmarshal([]byte(sent.fields["media"]), &media); err != nil {
+		t.Fatalf("media field %q: %v", sent.fields["media"], err)
+	}
+	if len(media) != 4 {
+		t.Fatalf("%d media items, want 4", len(media))
+	}
+	if media[0].Media != "AgADBAAD" || media[2].Media != "https://example.com/v.mp4" {
+		t.Errorf("file_id and URL media = %q, %q", media[0].Media, media[2].Media)
+	}
+	wantUploads := map[string]string{
+		media[1].Media: "cat data",
+		media[3].Media: "dog data",
+		media[3].Thumb: "thumb data",
+	}
+	for reference, content := range wantUploads {
+		if !strings.HasPrefix(reference, "attach://") {
+			t.Errorf("upload referenced as %q", reference)
+			continue
+		}
+		part := strings.TrimPrefix(reference, "attach://")
+		if sent.fields[part] != content || sent.fileNames[part] == "" {
+			t.Errorf("part %s has %q, want file with %q", part, sent.fields[part], content)
+		}
+	}
+	if len(sent.fileNames) != 3 {
+		t.Errorf("%d files uploaded, want 3: %v", len(sent.fileNames), sent.fieldOrder)
+	}
+}
+
+func TestSendMediaGroupWithoutUploadsIsJSON(t *testing.T) {
+	server := &recordingServer{}
+	server.statuses = []int{200}
+	server.responses = []string{`{"ok":true,"result":[]}`}
+	client, closeAPI := newRecordingClient(t, server)
+	defer closeAPI()
+	request := SendMediaGroupRequest{Media: []InputMedia{
+		{Type: InputMediaTypePhoto, Media: InputFileID("a")},
+		{Type: InputMediaTypePhoto, Media: InputFileID("b")},
+	}}
+	request.ChatID = 1
+	if _, err := client.SendMediaGroup(request); err != nil {
+		t.Fatal(err)
+	}
+	if sent := server.requests[0]; !strings.Contains(sent.fields["json"], `"media":"a"`) {
+		t.Fatalf("sent %s as %s", sent.fields["json"], sent.contentType)
+	}
+}
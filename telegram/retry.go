@@ -0,0 +1,105 @@
+package telegram
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// RetryPolicy controls how failed requests are repeated. Zero value disables retries.
+//
+// Requests rejected by flood control (HTTP 429) were not processed by Bot API, so they are repeated
+// for any method after waiting retry_after seconds. Server errors (HTTP 5xx) and network failures
+// may happen after request was processed, so they are repeated only for methods safe to repeat
+// (get*, set*, delete*, edit*) with exponential backoff and jitter.
+type RetryPolicy struct {
+	// MaxAttempts is total number of attempts including the first one
+	MaxAttempts int
+	// BaseDelay is backoff before the first retry, it doubles with every next attempt
+	BaseDelay time.Duration
+	// MaxDelay caps exponential backoff
+	MaxDelay time.Duration
+	// MaxRetryAfter gives up on 429 responses asking to wait longer than that, zero means no limit
+	MaxRetryAfter time.Duration
+}
+
+// DefaultRetryPolicy is reasonable policy for most bots
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:   5,
+		BaseDelay:     500 * time.Millisecond,
+		MaxDelay:      30 * time.Second,
+		MaxRetryAfter: 5 * time.Minute,
+	}
+}
+
+// WithRetryPolicy enables retries of failed requests
+func WithRetryPolicy(policy RetryPolicy) ClientOption {
+	return func(c *BaseClient) {
+		c.retryPolicy = policy
+	}
+}
+
+// backoff decides whether failed attempt should be repeated and how long to wait before that
+func (p RetryPolicy) backoff(method string, attempt int, err error) (time.Duration, bool) {
+	if attempt >= p.MaxAttempts {
+		return 0, false
+	}
+	apiErr, isAPIErr := AsAPIError(err)
+	switch {
+	case isAPIErr && apiErr.ErrorCode == http.StatusTooManyRequests:
+		delay := apiErr.RetryAfter()
+		if delay == 0 {
+			return p.exponential(attempt), true
+		}
+		if p.MaxRetryAfter > 0 && delay > p.MaxRetryAfter {
+			return 0, false
+		}
+		return delay, true
+	case isAPIErr && apiErr.StatusCode < http.StatusInternalServerError:
+		return 0, false
+	case !isSafeToRepeat(method):
+		return 0, false
+	default:
+		return p.exponential(attempt), true
+	}
+}
+
+func (p RetryPolicy) exponential(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && (p.MaxDelay <= 0 || delay < p.MaxDelay); i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	// equal jitter keeps at least half of the delay and spreads the rest
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
+
+// isSafeToRepeat reports whether processing the request twice has the same effect as processing it once
+func isSafeToRepeat(method string) bool {
+	for _, prefix := range []string{"get", "set", "delete", "edit"} {
+		if strings.HasPrefix(method, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
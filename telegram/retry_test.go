@@ -0,0 +1,83 @@
+package telegram
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{
+		MaxAttempts:   3,
+		BaseDelay:     100 * time.Millisecond,
+		MaxDelay:      time.Second,
+		MaxRetryAfter: time.Minute,
+	}
+	tooMany := func(retryAfter int) error {
+		return &APIError{StatusCode: 429, ErrorCode: 429, Parameters: ResponseParameters{RetryAfter: retryAfter}}
+	}
+	serverError := &APIError{StatusCode: 502, ErrorCode: 502}
+	badRequest := &APIError{StatusCode: 400, ErrorCode: 400}
+	networkError := errors.New("connection reset")
+
+	tests := []struct {
+		name      string
+		method    string
+		attempt   int
+		err       error
+		retry     bool
+		delay     time.Duration
+		anyJitter bool
+	}{
+		{name: "429 waits retry_after", method: "sendMessage", attempt: 1, err: tooMany(7), retry: true, delay: 7 * time.Second},
+		{name: "429 retry_after too long", method: "sendMessage", attempt: 1, err: tooMany(120), retry: false},
+		{name: "429 without retry_after backs off", method: "sendMessage", attempt: 1, err: tooMany(0), retry: true, anyJitter: true},
+		{name: "client error", method: "getMe", attempt: 1, err: badRequest, retry: false},
+		{name: "server error on safe method", method: "getUpdates", attempt: 1, err: serverError, retry: true, anyJitter: true},
+		{name: "server error on send", method: "sendMessage", attempt: 1, err: serverError, retry: false},
+		{name: "network error on safe method", method: "editMessageText", attempt: 2, err: networkError, retry: true, anyJitter: true},
+		{name: "network error on send", method: "sendPhoto", attempt: 1, err: networkError, retry: false},
+		{name: "attempts exhausted", method: "sendMessage", attempt: 3, err: tooMany(1), retry: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delay, retry := policy.backoff(tt.method, tt.attempt, tt.err)
+			if retry != tt.retry {
+				t.Fatalf("retry = %v, want %v", retry, tt.retry)
+			}
+			if !retry {
+				return
+			}
+			if tt.anyJitter {
+				if delay <= 0 || delay > policy.MaxDelay {
+					t.Fatalf("delay = %s, want within (0, %s]", delay, policy.MaxDelay)
+				}
+				return
+			}
+			if delay != tt.delay {
+				t.Fatalf("delay = %s, want %s", delay, tt.delay)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyZeroValueDisablesRetries(t *testing.T) {
+	if _, retry := (RetryPolicy{}).backoff("getMe", 1, &APIError{StatusCode: http.StatusBadGateway}); retry {
+		t.Fatal("zero policy retried")
+	}
+}
+
+func TestExponentialBackoffIsCapped(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: 300 * time.Millisecond}
+	for attempt := 1; attempt <= 10; attempt++ {
+		full := policy.BaseDelay << uint(attempt-1)
+		if full > policy.MaxDelay || full <= 0 {
+			full = policy.MaxDelay
+		}
+		delay := policy.exponential(attempt)
+		if delay < full/2 || delay > full {
+			t.Fatalf("attempt %d: delay %s outside [%s, %s]", attempt, delay, full/2, full)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package telegram
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestWithHTTPClientDropsTimeoutShorterThanLongPolling(t *testing.T) {
+	tests := []struct {
+		name        string
+		timeout     time.Duration
+		longPolling int
+		opts        []ClientOption
+		want        time.Duration
+	}{
+		{"no timeout", 0, 60, nil, 0},
+		{"shorter than long polling", 30 * time.Second, 60, nil, 0},
+		{"shorter than long polling plus request timeout", 70 * time.Second, 60, nil, 0},
+		{"long enough", 2 * time.Minute, 60, nil, 2 * time.Minute},
+		{"long enough without request timeout", 70 * time.Second, 60, []ClientOption{WithRequestTimeout(0)}, 70 * time.Second},
+	}
+	for _, test := range tests {
+		httpClient := &http.Client{Timeout: test.timeout}
+		client := NewClient("token", test.longPolling, append(test.opts, WithHTTPClient(httpClient))...)
+		if client.httpClient.Timeout != test.want {
+			t.Errorf("%s: timeout = %s, want %s", test.name, client.httpClient.Timeout, test.want)
+		}
+		if httpClient.Timeout != test.timeout {
+			t.Errorf("%s: given http client was changed", test.name)
+		}
+	}
+}
@@ -217,7 +217,7 @@ type Contact struct {
 	FirstName   string `json:"first_name"`
 	LastName    string `json:"last_name"`
 	UserID      int    `json:"user_id"`
-	VCard       string `json:"v_card"`
+	VCard       string `json:"vcard"`
 }
 
 type Location struct {
@@ -366,6 +366,14 @@ type File struct {
 	FilePath string `json:"file_path"`
 }
 
+// values of Chat.Type
+const (
+	ChatTypePrivate    = "private"
+	ChatTypeGroup      = "group"
+	ChatTypeSupergroup = "supergroup"
+	ChatTypeChannel    = "channel"
+)
+
 type EntityType string
 
 const (
@@ -392,6 +400,10 @@ type ChatRequest struct {
 	ChatID int `json:"chat_id"`
 }
 
+func (c ChatRequest) targetChatID() int {
+	return c.ChatID
+}
+
 func (c ChatRequest) FillFormData(m *map[string]io.Reader, reader io.Reader) {
 	if c.ChatID != 0 {
 		(*m)["chat_id"] = strings.NewReader(strconv.Itoa(c.ChatID))
@@ -441,7 +453,7 @@ type CaptionSource struct {
 
 func (c CaptionSource) FillFormData(m *map[string]io.Reader, reader io.Reader) *map[string]io.Reader {
 	if c.Caption != "" {
-		(*m)["reply_to_message_id"] = strings.NewReader(c.Caption)
+		(*m)["caption"] = strings.NewReader(c.Caption)
 	}
 	return m
 }
@@ -452,22 +464,29 @@ type DisableNotificationsSource struct {
 
 func (c DisableNotificationsSource) FillFormData(m *map[string]io.Reader, reader io.Reader) *map[string]io.Reader {
 	if c.DisableNotification != false {
-		(*m)["disable_notifications"] = strings.NewReader(strconv.FormatBool(c.DisableNotification))
+		(*m)["disable_notification"] = strings.NewReader(strconv.FormatBool(c.DisableNotification))
 	}
 	return m
 }
 
 type ThumbSource struct {
-	Thumb string `json:"thumb,omitempty"`
+	Thumb *InputFile `json:"thumb,omitempty"`
 }
 
 func (c ThumbSource) FillFormData(m *map[string]io.Reader, reader io.Reader) *map[string]io.Reader {
-	if c.Thumb != "" {
-		(*m)["thumb"] = strings.NewReader(c.Thumb)
+	if c.Thumb != nil && !c.Thumb.IsZero() {
+		(*m)["thumb"] = c.Thumb.formReader()
 	}
 	return m
 }
 
+func (c ThumbSource) thumb() InputFile {
+	if c.Thumb == nil {
+		return InputFile{}
+	}
+	return *c.Thumb
+}
+
 type SendMessageRequest struct {
 	ChatRequest
 	Text                  string `json:"text"`
@@ -490,7 +509,7 @@ type DeleteMessageRequest struct {
 }
 
 type SendPhotoRequest struct {
-	Photo string `json:"photo"`
+	Photo InputFile `json:"photo"`
 	ChatRequest
 	CaptionSource
 	ParseModeSource
@@ -506,15 +525,15 @@ func (c SendPhotoRequest) FillFormData(m *map[string]io.Reader, reader io.Reader
 	c.DisableNotificationsSource.FillFormData(m, nil)
 	c.ReplyToMessageIDSource.FillFormData(m, nil)
 	c.ReplyMarkupSource.FillFormData(m, nil)
-	(*m)["photo"] = reader
+	(*m)["photo"] = c.Photo.formReader()
 	return m
 }
 
 type SendAudioRequest struct {
-	Audio     string `json:"audio"`
-	Duration  int    `json:"duration"`
-	Performer string `json:"performer"`
-	Title     string `json:"title"`
+	Audio     InputFile `json:"audio"`
+	Duration  int       `json:"duration"`
+	Performer string    `json:"performer"`
+	Title     string    `json:"title"`
 	ChatRequest
 	ThumbSource
 	CaptionSource
@@ -535,12 +554,12 @@ func (c SendAudioRequest) FillFormData(m *map[string]io.Reader, reader io.Reader
 	(*m)["duration"] = strings.NewReader(strconv.Itoa(c.Duration))
 	(*m)["performer"] = strings.NewReader(c.Performer)
 	(*m)["title"] = strings.NewReader(c.Title)
-	(*m)["audio"] = reader
+	(*m)["audio"] = c.Audio.formReader()
 	return m
 }
 
 type SendDocumentRequest struct {
-	Document string `json:"document"`
+	Document InputFile `json:"document"`
 	ChatRequest
 	ThumbSource
 	CaptionSource
@@ -558,16 +577,16 @@ func (c SendDocumentRequest) FillFormData(m *map[string]io.Reader, reader io.Rea
 	c.DisableNotificationsSource.FillFormData(m, nil)
 	c.ReplyToMessageIDSource.FillFormData(m, nil)
 	c.ReplyMarkupSource.FillFormData(m, nil)
-	(*m)["document"] = reader
+	(*m)["document"] = c.Document.formReader()
 	return m
 }
 
 type SendVideoRequest struct {
-	Video             string `json:"video"`
-	Duration          int    `json:"duration"`
-	Width             int    `json:"width"`
-	Height            int    `json:"height"`
-	SupportsStreaming bool   `json:"supports_streaming"`
+	Video             InputFile `json:"video"`
+	Duration          int       `json:"duration"`
+	Width             int       `json:"width"`
+	Height            int       `json:"height"`
+	SupportsStreaming bool      `json:"supports_streaming"`
 	ChatRequest
 	ThumbSource
 	CaptionSource
@@ -585,7 +604,7 @@ func (c SendVideoRequest) FillFormData(m *map[string]io.Reader, reader io.Reader
 	c.DisableNotificationsSource.FillFormData(m, nil)
 	c.ReplyToMessageIDSource.FillFormData(m, nil)
 	c.ReplyMarkupSource.FillFormData(m, nil)
-	(*m)["video"] = reader
+	(*m)["video"] = c.Video.formReader()
 	(*m)["duration"] = strings.NewReader(strconv.Itoa(c.Duration))
 	(*m)["width"] = strings.NewReader(strconv.Itoa(c.Width))
 	(*m)["height"] = strings.NewReader(strconv.Itoa(c.Height))
@@ -594,10 +613,10 @@ func (c SendVideoRequest) FillFormData(m *map[string]io.Reader, reader io.Reader
 }
 
 type SendAnimationRequest struct {
-	Animation string `json:"animation"`
-	Duration  int    `json:"duration"`
-	Width     int    `json:"width"`
-	Height    int    `json:"height"`
+	Animation InputFile `json:"animation"`
+	Duration  int       `json:"duration"`
+	Width     int       `json:"width"`
+	Height    int       `json:"height"`
 	ChatRequest
 	ThumbSource
 	CaptionSource
@@ -615,7 +634,7 @@ func (c SendAnimationRequest) FillFormData(m *map[string]io.Reader, reader io.Re
 	c.DisableNotificationsSource.FillFormData(m, nil)
 	c.ReplyToMessageIDSource.FillFormData(m, nil)
 	c.ReplyMarkupSource.FillFormData(m, nil)
-	(*m)["animation"] = reader
+	(*m)["animation"] = c.Animation.formReader()
 	(*m)["duration"] = strings.NewReader(strconv.Itoa(c.Duration))
 	(*m)["width"] = strings.NewReader(strconv.Itoa(c.Width))
 	(*m)["height"] = strings.NewReader(strconv.Itoa(c.Height))
@@ -623,8 +642,8 @@ func (c SendAnimationRequest) FillFormData(m *map[string]io.Reader, reader io.Re
 }
 
 type SendVoiceRequest struct {
-	Voice    string `json:"voice"`
-	Duration int    `json:"duration"`
+	Voice    InputFile `json:"voice"`
+	Duration int       `json:"duration"`
 	ChatRequest
 	CaptionSource
 	ParseModeSource
@@ -634,16 +653,22 @@ type SendVoiceRequest struct {
 }
 
 func (c SendVoiceRequest) FillFormData(m *map[string]io.Reader, reader io.Reader) *map[string]io.Reader {
-	(*m)["voice"] = reader
+	c.ChatRequest.FillFormData(m, nil)
+	c.CaptionSource.FillFormData(m, nil)
+	c.ParseModeSource.FillFormData(m, nil)
+	c.DisableNotificationsSource.FillFormData(m, nil)
+	c.ReplyToMessageIDSource.FillFormData(m, nil)
+	c.ReplyMarkupSource.FillFormData(m, nil)
+	(*m)["voice"] = c.Voice.formReader()
 	(*m)["duration"] = strings.NewReader(strconv.Itoa(c.Duration))
 	return m
 }
 
 type SendVideoNoteRequest struct {
 	ChatRequest
-	VideoNote string `json:"video_note"`
-	Duration  int    `json:"duration"`
-	Length    int    `json:"length"`
+	VideoNote InputFile `json:"video_note"`
+	Duration  int       `json:"duration"`
+	Length    int       `json:"length"`
 	ThumbSource
 	DisableNotificationsSource
 	ReplyToMessageIDSource
@@ -651,24 +676,96 @@ type SendVideoNoteRequest struct {
 }
 
 func (c SendVideoNoteRequest) FillFormData(m *map[string]io.Reader, reader io.Reader) *map[string]io.Reader {
-	(*m)["video_note"] = reader
+	c.ChatRequest.FillFormData(m, nil)
+	c.ThumbSource.FillFormData(m, nil)
+	c.DisableNotificationsSource.FillFormData(m, nil)
+	c.ReplyToMessageIDSource.FillFormData(m, nil)
+	c.ReplyMarkupSource.FillFormData(m, nil)
+	(*m)["video_note"] = c.VideoNote.formReader()
 	(*m)["duration"] = strings.NewReader(strconv.Itoa(c.Duration))
 	(*m)["length"] = strings.NewReader(strconv.Itoa(c.Length))
 	return m
 }
 
+type InputMediaType string
+
+const (
+	InputMediaTypePhoto     InputMediaType = "photo"
+	InputMediaTypeVideo     InputMediaType = "video"
+	InputMediaTypeAnimation InputMediaType = "animation"
+	InputMediaTypeAudio     InputMediaType = "audio"
+	InputMediaTypeDocument  InputMediaType = "document"
+)
+
+// InputMedia is a photo or a video of media group, fields not applicable to Type are left empty
+type InputMedia struct {
+	Type  InputMediaType `json:"type"`
+	Media InputFile      `json:"media"`
+	ThumbSource
+	CaptionSource
+	ParseModeSource
+	Width             int    `json:"width,omitempty"`
+	Height            int    `json:"height,omitempty"`
+	Duration          int    `json:"duration,omitempty"`
+	Performer         string `json:"performer,omitempty"`
+	Title             string `json:"title,omitempty"`
+	SupportsStreaming bool   `json:"supports_streaming,omitempty"`
+}
+
 type SendMediaGroupRequest struct {
 	ChatRequest
-	Media []interface{} `json:"media"`
+	Media []InputMedia `json:"media"`
 	DisableNotificationsSource
 	ReplyToMessageIDSource
 }
 
+// FillFormData adds files to upload as separate fields referenced from media by attach://<field name>
+func (c SendMediaGroupRequest) FillFormData(m *map[string]io.Reader, reader io.Reader) *map[string]io.Reader {
+	c.ChatRequest.FillFormData(m, nil)
+	c.DisableNotificationsSource.FillFormData(m, nil)
+	c.ReplyToMessageIDSource.FillFormData(m, nil)
+	media := make([]InputMedia, 0, len(c.Media))
+	for i, item := range c.Media {
+		if item.Media.NeedsUpload() {
+			fieldName := "media" + strconv.Itoa(i)
+			(*m)[fieldName] = item.Media.formReader()
+			item.Media = inputFileAttach(fieldName)
+		}
+		if thumb := item.thumb(); thumb.NeedsUpload() {
+			fieldName := "thumb" + strconv.Itoa(i)
+			(*m)[fieldName] = thumb.formReader()
+			attach := inputFileAttach(fieldName)
+			item.Thumb = &attach
+		}
+		media = append(media, item)
+	}
+	jsonBytes, err := json.Marshal(media)
+	if err != nil {
+		logrus.WithError(err).Warn("cannot serialize media group to json")
+	} else {
+		(*m)["media"] = bytes.NewReader(jsonBytes)
+	}
+	return m
+}
+
+func (c SendMediaGroupRequest) files() []InputFile {
+	files := make([]InputFile, 0, len(c.Media))
+	for _, item := range c.Media {
+		files = append(files, item.Media, item.thumb())
+	}
+	return files
+}
+
+// messageCount is number of messages in group, each counts against rate limits
+func (c SendMediaGroupRequest) messageCount() int {
+	return len(c.Media)
+}
+
 type SendLocationRequest struct {
 	ChatRequest
 	Latitude   float64 `json:"latitude"`
 	Longitude  float64 `json:"longitude"`
-	LivePeriod int     `json:"live_period"`
+	LivePeriod int     `json:"live_period,omitempty"`
 	DisableNotificationsSource
 	ReplyToMessageIDSource
 	ReplyMarkupSource
@@ -679,7 +776,7 @@ type SendContactRequest struct {
 	PhoneNumber string `json:"phone_number"`
 	FirstName   string `json:"first_name"`
 	LastName    string `json:"last_name"`
-	VCard       string `json:"v_card"`
+	VCard       string `json:"vcard"`
 	DisableNotificationsSource
 	ReplyToMessageIDSource
 	ReplyMarkupSource
@@ -716,8 +813,8 @@ const (
 
 type GetUserProfilePhotosRequest struct {
 	UserID int `json:"user_id"`
-	Offset int `json:"offset"`
-	Limit  int `json:"limit"`
+	Offset int `json:"offset,omitempty"`
+	Limit  int `json:"limit,omitempty"`
 }
 
 type UserProfilePhotos struct {
@@ -731,11 +828,13 @@ type GetFileRequest struct {
 
 type SetChatPhotoRequest struct {
 	ChatRequest
-	Photo string `json:"photo"`
+	Photo InputFile `json:"photo"`
 }
 
-func (c SetChatPhotoRequest) FillFormData(m *map[string]io.Reader, reader io.Reader) {
-	(*m)["photo"] = reader
+func (c SetChatPhotoRequest) FillFormData(m *map[string]io.Reader, reader io.Reader) *map[string]io.Reader {
+	c.ChatRequest.FillFormData(m, nil)
+	(*m)["photo"] = c.Photo.formReader()
+	return m
 }
 
 type SetChatTitleRequest struct {
@@ -751,7 +850,7 @@ type SetChatDescriptionRequest struct {
 type PinChatMessageRequest struct {
 	ChatRequest
 	MessageID            int  `json:"message_id"`
-	DisableNotifications bool `json:"disable_notifications"`
+	DisableNotifications bool `json:"disable_notification"`
 }
 
 type ChatMember struct {
@@ -820,6 +919,111 @@ type EditMessageReplyMarkupRequest struct {
 	ReplyMarkup     interface{} `json:"reply_markup"`
 }
 
+type SetWebhookRequest struct {
+	URL string `json:"url"`
+	// Certificate is public key of self-signed certificate, it has to be uploaded
+	Certificate        *InputFile   `json:"certificate,omitempty"`
+	IPAddress          string       `json:"ip_address,omitempty"`
+	MaxConnections     int          `json:"max_connections,omitempty"`
+	AllowedUpdates     []UpdateType `json:"allowed_updates,omitempty"`
+	DropPendingUpdates bool         `json:"drop_pending_updates,omitempty"`
+	// SecretToken is sent back in X-Telegram-Bot-Api-Secret-Token header of every webhook request
+	SecretToken string `json:"secret_token,omitempty"`
+}
+
+func (c SetWebhookRequest) FillFormData(m *map[string]io.Reader, reader io.Reader) *map[string]io.Reader {
+	(*m)["url"] = strings.NewReader(c.URL)
+	if c.Certificate != nil {
+		(*m)["certificate"] = c.Certificate.formReader()
+	}
+	if c.IPAddress != "" {
+		(*m)["ip_address"] = strings.NewReader(c.IPAddress)
+	}
+	if c.MaxConnections != 0 {
+		(*m)["max_connections"] = strings.NewReader(strconv.Itoa(c.MaxConnections))
+	}
+	if len(c.AllowedUpdates) != 0 {
+		jsonBytes, err := json.Marshal(c.AllowedUpdates)
+		if err != nil {
+			logrus.WithError(err).Warn("cannot serialize allowed updates to json")
+		} else {
+			(*m)["allowed_updates"] = bytes.NewReader(jsonBytes)
+		}
+	}
+	if c.DropPendingUpdates {
+		(*m)["drop_pending_updates"] = strings.NewReader(strconv.FormatBool(c.DropPendingUpdates))
+	}
+	if c.SecretToken != "" {
+		(*m)["secret_token"] = strings.NewReader(c.SecretToken)
+	}
+	return m
+}
+
+func (c SetWebhookRequest) certificate() InputFile {
+	if c.Certificate == nil {
+		return InputFile{}
+	}
+	return *c.Certificate
+}
+
+type DeleteWebhookRequest struct {
+	DropPendingUpdates bool `json:"drop_pending_updates,omitempty"`
+}
+
+type WebhookInfo struct {
+	URL                          string       `json:"url"`
+	HasCustomCertificate         bool         `json:"has_custom_certificate"`
+	PendingUpdateCount           int          `json:"pending_update_count"`
+	IPAddress                    string       `json:"ip_address"`
+	LastErrorDate                int          `json:"last_error_date"`
+	LastErrorMessage             string       `json:"last_error_message"`
+	LastSynchronizationErrorDate int          `json:"last_synchronization_error_date"`
+	MaxConnections               int          `json:"max_connections"`
+	AllowedUpdates               []UpdateType `json:"allowed_updates"`
+}
+
+type BotCommand struct {
+	// Command is 1-32 lowercase letters, digits and underscores, without leading slash
+	Command     string `json:"command"`
+	Description string `json:"description"`
+}
+
+// values of BotCommandScope.Type
+const (
+	BotCommandScopeDefault               = "default"
+	BotCommandScopeAllPrivateChats       = "all_private_chats"
+	BotCommandScopeAllGroupChats         = "all_group_chats"
+	BotCommandScopeAllChatAdministrators = "all_chat_administrators"
+	BotCommandScopeChat                  = "chat"
+	BotCommandScopeChatAdministrators    = "chat_administrators"
+	BotCommandScopeChatMember            = "chat_member"
+)
+
+// BotCommandScope tells which users see command list, ChatID is set for chat scopes and UserID for chat_member
+type BotCommandScope struct {
+	Type   string `json:"type"`
+	ChatID int    `json:"chat_id,omitempty"`
+	UserID int    `json:"user_id,omitempty"`
+}
+
+type SetMyCommandsRequest struct {
+	Commands []BotCommand `json:"commands"`
+	// Scope defaults to BotCommandScopeDefault
+	Scope *BotCommandScope `json:"scope,omitempty"`
+	// LanguageCode is two-letter ISO 639-1 code, empty applies to users with no dedicated commands for their language
+	LanguageCode string `json:"language_code,omitempty"`
+}
+
+type GetMyCommandsRequest struct {
+	Scope        *BotCommandScope `json:"scope,omitempty"`
+	LanguageCode string           `json:"language_code,omitempty"`
+}
+
+type DeleteMyCommandsRequest struct {
+	Scope        *BotCommandScope `json:"scope,omitempty"`
+	LanguageCode string           `json:"language_code,omitempty"`
+}
+
 type GetUpdatesRequest struct {
 	Offset         int          `json:"offset"`
 	Limit          int          `json:"limit"`
@@ -841,3 +1045,46 @@ const (
 	UpdateTypePreCheckoutQuery   UpdateType = "pre_checkout_query"
 	UpdateTypePoll               UpdateType = "poll"
 )
+
+// AllUpdateTypes lists every update type, in order updates are checked by Update.Type
+func AllUpdateTypes() []UpdateType {
+	return []UpdateType{
+		UpdateTypeMessage,
+		UpdateTypeEditedMessage,
+		UpdateTypeChannelPost,
+		UpdateTypeEditedChannelPost,
+		UpdateTypeInlineResult,
+		UpdateTypeChosenInlineResult,
+		UpdateTypeCallbackQuery,
+		UpdateTypeShippingQuery,
+		UpdateTypePreCheckoutQuery,
+		UpdateTypePoll,
+	}
+}
+
+// Type tells which kind of update this is, empty if update has none of known kinds
+func (u Update) Type() UpdateType {
+	switch {
+	case u.Message != nil:
+		return UpdateTypeMessage
+	case u.EditedMessage != nil:
+		return UpdateTypeEditedMessage
+	case u.ChannelPost != nil:
+		return UpdateTypeChannelPost
+	case u.EditedChannelPost != nil:
+		return UpdateTypeEditedChannelPost
+	case u.InlineQuery != nil:
+		return UpdateTypeInlineResult
+	case u.ChosenInlineResult != nil:
+		return UpdateTypeChosenInlineResult
+	case u.CallbackQuery != nil:
+		return UpdateTypeCallbackQuery
+	case u.ShippingQuery != nil:
+		return UpdateTypeShippingQuery
+	case u.PreCheckoutQuery != nil:
+		return UpdateTypePreCheckoutQuery
+	case u.Poll != nil:
+		return UpdateTypePoll
+	}
+	return ""
+}
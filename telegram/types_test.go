@@ -0,0 +1,36 @@
+package telegram
+
+import "testing"
+
+func TestUpdateType(t *testing.T) {
+	tests := []struct {
+		update Update
+		want   UpdateType
+	}{
+		{Update{Message: &Message{}}, UpdateTypeMessage},
+		{Update{EditedMessage: &Message{}}, UpdateTypeEditedMessage},
+		{Update{ChannelPost: &Message{}}, UpdateTypeChannelPost},
+		{Update{EditedChannelPost: &Message{}}, UpdateTypeEditedChannelPost},
+		{Update{InlineQuery: &InlineQuery{}}, UpdateTypeInlineResult},
+		{Update{ChosenInlineResult: &ChosenInlineResult{}}, UpdateTypeChosenInlineResult},
+		{Update{CallbackQuery: &CallbackQuery{}}, UpdateTypeCallbackQuery},
+		{Update{ShippingQuery: &ShippingQuery{}}, UpdateTypeShippingQuery},
+		{Update{PreCheckoutQuery: &PreCheckoutQuery{}}, UpdateTypePreCheckoutQuery},
+		{Update{Poll: &Poll{}}, UpdateTypePoll},
+		{Update{UpdateID: 1}, ""},
+	}
+	for _, test := range tests {
+		if got := test.update.Type(); got != test.want {
+			t.Errorf("Type() of %+v = %q, want %q", test.update, got, test.want)
+		}
+	}
+	seen := map[UpdateType]bool{}
+	for _, test := range tests {
+		seen[test.want] = true
+	}
+	for _, updateType := range AllUpdateTypes() {
+		if !seen[updateType] {
+			t.Errorf("update type %q is not covered", updateType)
+		}
+	}
+}
@@ -13,7 +13,7 @@ func main() {
 	msg.ChatID = 139455782
 	msg.Caption = "<b>ololo</b>"
 	msg.ParseMode = "HTML"
-	msg.Photo = "C:\\projects\\SVG\\bitmap.png"
+	msg.Photo = telegram.InputFilePath("C:\\projects\\SVG\\bitmap.png")
 	message, err := client.SendPhoto(msg)
 	if err != nil {
 		fmt.Printf("%v\n%v", message, err)